@@ -1,7 +1,16 @@
 package main
 
-import "github.com/iamsorryprincess/url-shortener/internal/app"
+import (
+	"os"
+
+	"github.com/iamsorryprincess/url-shortener/internal/app"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		app.RunMigrations(os.Args[2:])
+		return
+	}
+
 	app.Run()
 }
@@ -3,7 +3,12 @@ package app
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"io"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/iamsorryprincess/url-shortener/internal/config"
@@ -15,84 +20,226 @@ import (
 	_ "github.com/jackc/pgx/stdlib"
 )
 
+// Run exits with a non-zero status when startup or the server fails, once the
+// deferred calls of run have stopped the workers and flushed the storages.
 func Run() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
 	configuration, confErr := config.ParseConfiguration()
 
 	if confErr != nil {
-		log.Fatal(confErr)
-		return
+		return confErr
 	}
 
 	var db *sql.DB
-	var urlService *service.URLService
-	var batchWorker *worker.Worker
+	var err error
+	var urlStorage storage.Storage
+	var clickStorage storage.ClickStorage
+	var deletionJournal storage.DeletionJournal
+	var apiKeyStorage storage.APIKeyStorage
 
-	if configuration.StoragePath != "" && configuration.DBConnectionString != "" {
-		var err error
+	if configuration.DBConnectionString != "" {
 		db, err = initDB(configuration.DBConnectionString)
 
 		if err != nil {
-			log.Fatal(err)
-			return
+			return err
 		}
 
-		defer db.Close()
-		postgresqlStorage, err := storage.NewPostgresqlStorage(db)
+		defer closeAndLog(db)
+		urlStorage, err = storage.NewPostgresqlStorage(db)
 
 		if err != nil {
-			log.Fatal(err)
-			return
+			return err
 		}
 
-		urlService = service.NewURLService(postgresqlStorage, configuration.BaseURL)
-		batchWorker = worker.NewWorker(postgresqlStorage)
-	} else if configuration.DBConnectionString != "" {
-		var err error
-		db, err = initDB(configuration.DBConnectionString)
+		clickStorage = storage.NewPostgresqlClickStorage(db)
+		deletionJournal = storage.NewPostgresqlDeletionJournal(db)
+		apiKeyStorage = storage.NewPostgresqlAPIKeyStorage(db)
+	} else if configuration.StoragePath != "" {
+		fileStorage, file, err := storage.NewFileStorage(configuration.StoragePath)
 
 		if err != nil {
-			log.Fatal(err)
-			return
+			return err
 		}
 
-		defer db.Close()
-		postgresqlStorage, err := storage.NewPostgresqlStorage(db)
+		defer closeAndLog(file)
+		urlStorage = fileStorage
+		fileClickStorage, clicksFile, err := storage.NewFileClickStorage(configuration.ClicksStoragePath)
 
 		if err != nil {
-			log.Fatal(err)
-			return
+			return err
 		}
 
-		urlService = service.NewURLService(postgresqlStorage, configuration.BaseURL)
-		batchWorker = worker.NewWorker(postgresqlStorage)
-	} else if configuration.StoragePath != "" {
-		fileStorage, file, err := storage.NewFileStorage(configuration.StoragePath)
+		defer closeAndLog(clicksFile)
+		clickStorage = fileClickStorage
+		fileDeletionJournal, journalFile, err := storage.NewFileDeletionJournal(configuration.DeletionJournalPath)
 
 		if err != nil {
-			log.Fatal(err)
-			return
+			return err
 		}
 
-		defer file.Close()
-		urlService = service.NewURLService(fileStorage, configuration.BaseURL)
-		batchWorker = worker.NewWorker(fileStorage)
+		defer closeAndLog(journalFile)
+		deletionJournal = fileDeletionJournal
+		fileAPIKeyStorage, apiKeysFile, err := storage.NewFileAPIKeyStorage(configuration.APIKeysStoragePath)
+
+		if err != nil {
+			return err
+		}
+
+		defer closeAndLog(apiKeysFile)
+		apiKeyStorage = fileAPIKeyStorage
 	} else {
-		inMemoryStorage := storage.NewInMemoryStorage()
-		urlService = service.NewURLService(inMemoryStorage, configuration.BaseURL)
-		batchWorker = worker.NewWorker(inMemoryStorage)
+		urlStorage = storage.NewInMemoryStorage()
+		clickStorage = storage.NewInMemoryClickStorage()
+		deletionJournal = storage.NewInMemoryDeletionJournal()
+		apiKeyStorage = storage.NewInMemoryAPIKeyStorage()
+	}
+
+	// a cache in front of storage takes hot redirects off the database; a RESP
+	// server is shared between instances, the LRU is local to this process and
+	// only fits a single instance: other replicas never see its invalidations,
+	// which is why it stays off unless CACHE_SIZE is set
+	if configuration.CacheRESPAddress != "" {
+		cache, cacheCloser, err := storage.NewRESPCache(storage.RESPOptions{
+			Address:   configuration.CacheRESPAddress,
+			Password:  configuration.CacheRESPPassword,
+			KeyPrefix: configuration.CacheRESPKeyPrefix,
+			PoolSize:  configuration.CacheRESPPoolSize,
+			Timeout:   configuration.CacheRESPTimeout,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		defer closeAndLog(cacheCloser)
+		urlStorage = storage.NewCachedStorage(urlStorage, cache, configuration.CacheTTL, configuration.CacheNegativeTTL)
+	} else if configuration.CacheSize > 0 {
+		urlStorage = storage.NewCachedStorage(urlStorage, storage.NewLRUCache(configuration.CacheSize), configuration.CacheTTL, configuration.CacheNegativeTTL)
 	}
 
-	keyManager, err := hash.NewGcmKeyManager()
+	slugGenerator, err := service.NewSlugGenerator(
+		configuration.SlugGenerator,
+		configuration.SlugLength,
+		configuration.SlugSalt,
+		configuration.SlugCounterOffset,
+		urlStorage)
 
 	if err != nil {
-		log.Fatal(err)
-		return
+		return err
+	}
+
+	domainPolicy, err := service.NewDomainPolicy(service.PolicyFiles{
+		AllowlistPath: configuration.DomainAllowlistPath,
+		DenylistPath:  configuration.DomainDenylistPath,
+		BlocklistPath: configuration.BlocklistFeedPath,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	domainPolicy.Start(configuration.PolicyReloadInterval)
+	defer domainPolicy.Stop()
+	var geoIP *service.GeoIPDatabase
+
+	if configuration.GeoIPDatabasePath != "" {
+		geoIP, err = service.LoadGeoIPDatabase(configuration.GeoIPDatabasePath)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	urlService := service.NewURLService(
+		urlStorage,
+		configuration.BaseURL,
+		slugGenerator,
+		service.NewURLNormalizer(configuration.AllowedURLSchemes, configuration.MaxURLLength),
+		domainPolicy,
+		service.NewRulesEngine(geoIP))
+	statsService := service.NewStatsService(urlStorage, clickStorage, configuration.BaseURL)
+	apiKeyService := service.NewAPIKeyService(apiKeyStorage)
+	batchWorker := worker.NewWorker(urlStorage, deletionJournal, configuration.WorkerQueueSize)
+	sweeper := worker.NewSweeper(
+		urlStorage,
+		deletionJournal,
+		clickStorage,
+		configuration.SweeperInterval,
+		configuration.ExpiredURLsRetention,
+		configuration.DeletedURLsRetention,
+		configuration.DeletionJobsRetention,
+		configuration.ClicksRetention)
+	clickRecorder := worker.NewClickRecorder(
+		clickStorage,
+		configuration.ClicksBufferSize,
+		configuration.ClicksBatchSize,
+		configuration.ClicksFlushInterval)
+
+	keys, err := loadKeys(configuration)
+
+	if err != nil {
+		return err
+	}
+
+	keyManager, err := hash.NewGcmKeyManager(keys, configuration.AuthTokenTTL)
+
+	if err != nil {
+		return err
 	}
 
-	batchWorker.Start(configuration.WorkersCount, configuration.WorkerPoolSize)
+	batchWorker.Start(configuration.WorkersCount, configuration.WorkerPoolSize, configuration.WorkerFlushInterval)
 	defer batchWorker.Stop()
-	httpServer := server.NewServer(configuration, urlService, keyManager, db, batchWorker)
-	log.Fatal(httpServer.Run())
+	batchWorker.Report(configuration.WorkerReportInterval)
+
+	if err = batchWorker.Recover(context.Background()); err != nil {
+		log.Println(err)
+	}
+
+	sweeper.Start()
+	defer sweeper.Stop()
+	clickRecorder.Start()
+	defer clickRecorder.Stop()
+	httpServer := server.NewServer(configuration, urlService, statsService, apiKeyService, keyManager, db, batchWorker, clickRecorder)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	runErrs := make(chan error, 1)
+
+	go func() {
+		if runErr := httpServer.Run(); runErr != nil && !errors.Is(runErr, http.ErrServerClosed) {
+			runErrs <- runErr
+		}
+	}()
+
+	var runErr error
+
+	select {
+	case <-ctx.Done():
+	case runErr = <-runErrs:
+	}
+
+	log.Println("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), configuration.ShutdownTimeout)
+	defer cancel()
+
+	// deferred calls above stop the workers, flush storages and close the db in reverse order
+	if err = httpServer.Stop(shutdownCtx); err != nil {
+		log.Println(err)
+	}
+
+	return runErr
+}
+
+func closeAndLog(closer io.Closer) {
+	if err := closer.Close(); err != nil {
+		log.Println(err)
+	}
 }
 
 func initDB(connectionString string) (*sql.DB, error) {
@@ -112,3 +259,24 @@ func initDB(connectionString string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// loadKeys prefers the keys file over the AUTH_KEYS variable and falls back to
+// a random key, which invalidates every issued token on restart.
+func loadKeys(configuration *config.Configuration) ([]hash.Key, error) {
+	if configuration.AuthKeysPath != "" {
+		return hash.LoadKeys(configuration.AuthKeysPath)
+	}
+
+	if configuration.AuthKeys != "" {
+		return hash.ParseKeys(configuration.AuthKeys)
+	}
+
+	log.Println("no auth keys configured, using a random key: tokens will not survive a restart")
+	key, err := hash.GenerateKey("dev")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []hash.Key{key}, nil
+}
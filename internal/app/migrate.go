@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/config"
+	"github.com/iamsorryprincess/url-shortener/internal/storage/migrations"
+)
+
+const migrateUsage = "usage: shortener migrate [-d db connection string] up|down|status"
+
+func RunMigrations(args []string) {
+	configuration, commands, err := config.ParseMigrationConfiguration(args)
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if len(commands) != 1 {
+		log.Fatal(migrateUsage)
+		return
+	}
+
+	if configuration.DBConnectionString == "" {
+		log.Fatal(errors.New("db connection string is not set"))
+		return
+	}
+
+	db, err := initDB(configuration.DBConnectionString)
+
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch commands[0] {
+	case "up":
+		err = migrations.Up(ctx, db)
+	case "down":
+		err = migrations.Down(ctx, db)
+	case "status":
+		var statuses []migrations.Status
+		statuses, err = migrations.GetStatus(ctx, db)
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		log.Fatal(migrateUsage)
+		return
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,17 +2,65 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
 
 type Configuration struct {
-	Address            string `env:"SERVER_ADDRESS" envDefault:":8080"`
-	BaseURL            string `env:"BASE_URL" envDefault:"http://localhost:8080"`
-	StoragePath        string `env:"FILE_STORAGE_PATH"`
-	DBConnectionString string `env:"DATABASE_DSN" envDefault:""`
-	WorkersCount       int    `env:"WORKERS_COUNT" envDefault:"1"`
-	WorkerPoolSize     int    `env:"WORKER_POOL_SIZE" envDefault:"1"`
+	Address                 string        `env:"SERVER_ADDRESS" envDefault:":8080"`
+	BaseURL                 string        `env:"BASE_URL" envDefault:"http://localhost:8080"`
+	StoragePath             string        `env:"FILE_STORAGE_PATH"`
+	DBConnectionString      string        `env:"DATABASE_DSN" envDefault:""`
+	WorkersCount            int           `env:"WORKERS_COUNT" envDefault:"1"`
+	WorkerPoolSize          int           `env:"WORKER_POOL_SIZE" envDefault:"1"`
+	SlugGenerator           string        `env:"SLUG_GENERATOR" envDefault:"random"`
+	SlugLength              int           `env:"SLUG_LENGTH" envDefault:"8"`
+	SlugSalt                string        `env:"SLUG_SALT" envDefault:""`
+	SlugCounterOffset       uint64        `env:"SLUG_COUNTER_OFFSET" envDefault:"0"`
+	AllowedURLSchemes       []string      `env:"ALLOWED_URL_SCHEMES" envSeparator:"," envDefault:"http,https"`
+	MaxURLLength            int           `env:"MAX_URL_LENGTH" envDefault:"2048"`
+	RedirectMode            string        `env:"REDIRECT_MODE" envDefault:"307"`
+	InterstitialDelay       int           `env:"INTERSTITIAL_DELAY" envDefault:"5"`
+	GeoIPDatabasePath       string        `env:"GEOIP_DATABASE_FILE"`
+	DomainAllowlistPath     string        `env:"DOMAIN_ALLOWLIST_FILE"`
+	DomainDenylistPath      string        `env:"DOMAIN_DENYLIST_FILE"`
+	BlocklistFeedPath       string        `env:"BLOCKLIST_FEED_FILE"`
+	PolicyReloadInterval    time.Duration `env:"POLICY_RELOAD_INTERVAL" envDefault:"30s"`
+	SweeperInterval         time.Duration `env:"SWEEPER_INTERVAL" envDefault:"1m"`
+	ExpiredURLsRetention    time.Duration `env:"EXPIRED_URLS_RETENTION" envDefault:"24h"`
+	DeletedURLsRetention    time.Duration `env:"DELETED_URLS_RETENTION" envDefault:"720h"`
+	CacheSize               int           `env:"CACHE_SIZE" envDefault:"0"`
+	CacheTTL                time.Duration `env:"CACHE_TTL" envDefault:"5m"`
+	CacheNegativeTTL        time.Duration `env:"CACHE_NEGATIVE_TTL" envDefault:"10s"`
+	CacheRESPAddress        string        `env:"CACHE_RESP_ADDRESS"`
+	CacheRESPPassword       string        `env:"CACHE_RESP_PASSWORD"`
+	CacheRESPKeyPrefix      string        `env:"CACHE_RESP_KEY_PREFIX" envDefault:"url-shortener:"`
+	CacheRESPPoolSize       int           `env:"CACHE_RESP_POOL_SIZE" envDefault:"16"`
+	CacheRESPTimeout        time.Duration `env:"CACHE_RESP_TIMEOUT" envDefault:"100ms"`
+	ClicksStoragePath       string        `env:"CLICKS_FILE_STORAGE_PATH"`
+	ClicksBufferSize        int           `env:"CLICKS_BUFFER_SIZE" envDefault:"1024"`
+	ClicksBatchSize         int           `env:"CLICKS_BATCH_SIZE" envDefault:"100"`
+	ClicksFlushInterval     time.Duration `env:"CLICKS_FLUSH_INTERVAL" envDefault:"1s"`
+	ClicksRetention         time.Duration `env:"CLICKS_RETENTION" envDefault:"2160h"`
+	ShutdownTimeout         time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"10s"`
+	WorkerQueueSize         int           `env:"WORKER_QUEUE_SIZE" envDefault:"1000"`
+	WorkerFlushInterval     time.Duration `env:"WORKER_FLUSH_INTERVAL" envDefault:"1s"`
+	WorkerReportInterval    time.Duration `env:"WORKER_REPORT_INTERVAL" envDefault:"1m"`
+	DeletionJournalPath     string        `env:"DELETION_JOURNAL_PATH"`
+	DeletionJobsRetention   time.Duration `env:"DELETION_JOBS_RETENTION" envDefault:"24h"`
+	AuthKeys                string        `env:"AUTH_KEYS"`
+	AuthKeysPath            string        `env:"AUTH_KEYS_FILE"`
+	APIKeysStoragePath      string        `env:"API_KEYS_FILE_STORAGE_PATH"`
+	InvalidCookiePolicy     string        `env:"INVALID_COOKIE_POLICY" envDefault:"issue"`
+	UserInvalidCookiePolicy string        `env:"USER_INVALID_COOKIE_POLICY" envDefault:"reject"`
+	CookieHTTPOnly          bool          `env:"COOKIE_HTTP_ONLY" envDefault:"true"`
+	CookieSecure            bool          `env:"COOKIE_SECURE" envDefault:"false"`
+	CookieSameSite          string        `env:"COOKIE_SAME_SITE" envDefault:"lax"`
+	CookieMaxAge            time.Duration `env:"COOKIE_MAX_AGE" envDefault:"8760h"`
+	AuthTokenTTL            time.Duration `env:"AUTH_TOKEN_TTL" envDefault:"8760h"`
 }
 
 func ParseConfiguration() (*Configuration, error) {
@@ -27,6 +75,122 @@ func ParseConfiguration() (*Configuration, error) {
 	flag.StringVar(&configuration.BaseURL, "b", configuration.BaseURL, "base url")
 	flag.StringVar(&configuration.StoragePath, "f", configuration.StoragePath, "file storage path")
 	flag.StringVar(&configuration.DBConnectionString, "d", configuration.DBConnectionString, "db connection string")
+	flag.StringVar(&configuration.SlugGenerator, "s", configuration.SlugGenerator, "slug generator (counter, random, hashids)")
+	flag.IntVar(&configuration.SlugLength, "l", configuration.SlugLength, "slug length (random) or minimum slug length (hashids)")
 	flag.Parse()
+
+	// counter keys grow with the counter and have no length to set
+	if configuration.SlugGenerator == "counter" && isSlugLengthSet() {
+		return nil, fmt.Errorf("slug length does not apply to the counter slug generator")
+	}
+
+	// longer hashids keys need an offset that does not fit in uint64
+	if configuration.SlugGenerator == "hashids" && configuration.SlugLength > 12 {
+		return nil, fmt.Errorf("invalid slug length %d: the minimum length of hashids keys is at most 12", configuration.SlugLength)
+	}
+
+	if configuration.ClicksStoragePath == "" && configuration.StoragePath != "" {
+		configuration.ClicksStoragePath = configuration.StoragePath + ".clicks"
+	}
+
+	if configuration.DeletionJournalPath == "" && configuration.StoragePath != "" {
+		configuration.DeletionJournalPath = configuration.StoragePath + ".deletions"
+	}
+
+	for _, policy := range []string{configuration.InvalidCookiePolicy, configuration.UserInvalidCookiePolicy} {
+		if policy != "issue" && policy != "reject" {
+			return nil, fmt.Errorf("invalid cookie policy %q: must be issue or reject", policy)
+		}
+	}
+
+	switch configuration.RedirectMode {
+	case "301", "302", "307", "308", "interstitial":
+	default:
+		return nil, fmt.Errorf("invalid redirect mode %q: must be 301, 302, 307, 308 or interstitial", configuration.RedirectMode)
+	}
+
+	if configuration.InterstitialDelay < 0 || configuration.InterstitialDelay > 60 {
+		return nil, fmt.Errorf("invalid interstitial delay %d: must be between 0 and 60 seconds", configuration.InterstitialDelay)
+	}
+
+	switch configuration.CookieSameSite {
+	case "lax", "strict", "none":
+	default:
+		return nil, fmt.Errorf("invalid cookie same site %q: must be lax, strict or none", configuration.CookieSameSite)
+	}
+
+	// browsers drop SameSite=None cookies that are not Secure
+	if configuration.CookieSameSite == "none" && !configuration.CookieSecure {
+		return nil, fmt.Errorf("cookie same site none requires COOKIE_SECURE=true")
+	}
+
+	// the intervals drive tickers, which cannot tick every zero seconds
+	intervals := []struct {
+		name  string
+		value time.Duration
+	}{
+		{name: "SWEEPER_INTERVAL", value: configuration.SweeperInterval},
+		{name: "CLICKS_FLUSH_INTERVAL", value: configuration.ClicksFlushInterval},
+		{name: "WORKER_FLUSH_INTERVAL", value: configuration.WorkerFlushInterval},
+		{name: "WORKER_REPORT_INTERVAL", value: configuration.WorkerReportInterval},
+		{name: "POLICY_RELOAD_INTERVAL", value: configuration.PolicyReloadInterval},
+	}
+
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			return nil, fmt.Errorf("invalid %s %s: must be positive", interval.name, interval.value)
+		}
+	}
+
+	// a worker without consumers or with empty pools never deletes anything
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{name: "WORKERS_COUNT", value: configuration.WorkersCount},
+		{name: "WORKER_POOL_SIZE", value: configuration.WorkerPoolSize},
+		{name: "WORKER_QUEUE_SIZE", value: configuration.WorkerQueueSize},
+	}
+
+	for _, count := range counts {
+		if count.value <= 0 {
+			return nil, fmt.Errorf("invalid %s %d: must be positive", count.name, count.value)
+		}
+	}
+
+	if configuration.APIKeysStoragePath == "" && configuration.StoragePath != "" {
+		configuration.APIKeysStoragePath = configuration.StoragePath + ".keys"
+	}
+
 	return configuration, nil
 }
+
+func isSlugLengthSet() bool {
+	_, set := os.LookupEnv("SLUG_LENGTH")
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "l" {
+			set = true
+		}
+	})
+
+	return set
+}
+
+func ParseMigrationConfiguration(args []string) (*Configuration, []string, error) {
+	configuration := &Configuration{}
+	err := env.Parse(configuration)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	flagSet := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flagSet.StringVar(&configuration.DBConnectionString, "d", configuration.DBConnectionString, "db connection string")
+
+	if err = flagSet.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	return configuration, flagSet.Args(), nil
+}
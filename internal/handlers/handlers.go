@@ -3,9 +3,12 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
-	"io"
+	"log"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/iamsorryprincess/url-shortener/internal/middleware"
 	"github.com/iamsorryprincess/url-shortener/internal/service"
 	"github.com/iamsorryprincess/url-shortener/internal/storage"
@@ -14,20 +17,16 @@ import (
 
 func RawMakeShortURLHandler(urlService *service.URLService) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		bytes, readErr := io.ReadAll(request.Body)
+		bytes, ok := readBody(writer, request)
 
-		if readErr != nil {
-			http.Error(writer, readErr.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if len(bytes) == 0 {
-			http.Error(writer, "empty body", http.StatusBadRequest)
+		if !ok {
 			return
 		}
 
 		url := string(bytes)
-		shortenURL, serviceErr := urlService.SaveURL(request.Context(), url, getUserID(request))
+		shortenURL, serviceErr := urlService.SaveURL(request.Context(), service.URLInput{
+			OriginalURL: url,
+		}, getUserID(request))
 
 		if serviceErr != nil {
 			var urlErr *service.URLUniqueError
@@ -36,7 +35,7 @@ func RawMakeShortURLHandler(urlService *service.URLService) http.HandlerFunc {
 				return
 			}
 
-			http.Error(writer, "internal error", http.StatusInternalServerError)
+			writeServiceError(writer, request, serviceErr)
 			return
 		}
 
@@ -45,7 +44,15 @@ func RawMakeShortURLHandler(urlService *service.URLService) http.HandlerFunc {
 }
 
 type URLRequest struct {
-	URL string `json:"url"`
+	URL               string         `json:"url"`
+	Alias             string         `json:"alias,omitempty"`
+	ExpiresAt         *time.Time     `json:"expires_at,omitempty"`
+	TTL               int64          `json:"ttl,omitempty"`
+	RedirectMode      string         `json:"redirect_mode,omitempty"`
+	InterstitialDelay int            `json:"interstitial_delay,omitempty"`
+	QueryMerge        string         `json:"query_merge,omitempty"`
+	Template          bool           `json:"template,omitempty"`
+	Rules             []storage.Rule `json:"rules,omitempty"`
 }
 
 type URLResponse struct {
@@ -54,37 +61,32 @@ type URLResponse struct {
 
 func JSONMakeShortURLHandler(urlService *service.URLService) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		bytes, readErr := io.ReadAll(request.Body)
-
-		if readErr != nil {
-			http.Error(writer, readErr.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if request.Header.Get("Content-Type") != "application/json" {
-			http.Error(writer, "invalid content type", 400)
-			return
-		}
-
-		if len(bytes) == 0 {
-			http.Error(writer, "empty body", http.StatusBadRequest)
+		if !requireJSON(writer, request) {
 			return
 		}
 
 		reqBody := URLRequest{}
-		deserializeErr := json.Unmarshal(bytes, &reqBody)
 
-		if deserializeErr != nil {
-			http.Error(writer, deserializeErr.Error(), http.StatusInternalServerError)
+		if !readJSON(writer, request, &reqBody) {
 			return
 		}
 
 		if reqBody.URL == "" {
-			http.Error(writer, "url is empty", http.StatusBadRequest)
+			writeProblem(writer, request, http.StatusBadRequest, CodeEmptyURL, "url is empty")
 			return
 		}
 
-		shortenURL, serviceErr := urlService.SaveURL(request.Context(), reqBody.URL, getUserID(request))
+		shortenURL, serviceErr := urlService.SaveURL(request.Context(), service.URLInput{
+			OriginalURL:       reqBody.URL,
+			Alias:             reqBody.Alias,
+			ExpiresAt:         reqBody.ExpiresAt,
+			TTL:               reqBody.TTL,
+			RedirectMode:      reqBody.RedirectMode,
+			InterstitialDelay: reqBody.InterstitialDelay,
+			QueryMerge:        reqBody.QueryMerge,
+			Template:          reqBody.Template,
+			Rules:             reqBody.Rules,
+		}, getUserID(request))
 
 		if serviceErr != nil {
 			var urlErr *service.URLUniqueError
@@ -93,7 +95,7 @@ func JSONMakeShortURLHandler(urlService *service.URLService) http.HandlerFunc {
 				return
 			}
 
-			http.Error(writer, "internal error", http.StatusInternalServerError)
+			writeServiceError(writer, request, serviceErr)
 			return
 		}
 
@@ -101,33 +103,49 @@ func JSONMakeShortURLHandler(urlService *service.URLService) http.HandlerFunc {
 	}
 }
 
-func GetFullURLHandler(urlService *service.URLService) http.HandlerFunc {
+func GetFullURLHandler(urlService *service.URLService, clickRecorder *worker.ClickRecorder, defaults RedirectDefaults) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		url := request.RequestURI[1:len(request.RequestURI)]
+		url := chi.URLParam(request, "URL")
 
 		if url == "" {
-			http.Error(writer, "empty url", http.StatusBadRequest)
+			writeProblem(writer, request, http.StatusBadRequest, CodeEmptyURL, "url is empty")
 			return
 		}
 
-		targetURL, err := urlService.GetURL(request.Context(), url)
+		link, err := urlService.GetLink(request.Context(), url, &service.Visitor{
+			UserAgent:      request.UserAgent(),
+			AcceptLanguage: request.Header.Get("Accept-Language"),
+			IP:             clientIP(request.RemoteAddr),
+			Time:           time.Now(),
+		})
+
+		// the link exists but its domain has been blocked since it was created
+		if errors.Is(err, service.ErrDomainBlocked) {
+			writeProblem(writer, request, http.StatusForbidden, CodeDomainBlocked, "the target of this link has been blocked")
+			return
+		}
 
 		if err != nil {
-			if errors.Is(err, storage.ErrIsDeleted) {
-				writer.WriteHeader(http.StatusGone)
-				return
-			}
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			writeServiceError(writer, request, err)
 			return
 		}
 
-		if targetURL == "" {
-			http.NotFound(writer, request)
+		// the path after the key fills the placeholders of template links
+		target, err := service.ResolveTarget(link, chi.URLParam(request, "*"), request.URL.Query())
+
+		if err != nil {
+			writeServiceError(writer, request, err)
 			return
 		}
 
-		writer.Header().Set("Location", targetURL)
-		writer.WriteHeader(http.StatusTemporaryRedirect)
+		clickRecorder.Record(storage.Click{
+			ShortURL:  url,
+			Timestamp: time.Now().UTC(),
+			Referrer:  request.Referer(),
+			UserAgent: request.UserAgent(),
+			ClientIP:  coarseClientIP(request.RemoteAddr),
+		})
+		writeRedirect(writer, target, link.Options, defaults)
 	}
 }
 
@@ -136,7 +154,7 @@ func GetUserUrls(urlService *service.URLService) http.HandlerFunc {
 		data, err := urlService.GetUserData(request.Context(), getUserID(request))
 
 		if err != nil {
-			writer.WriteHeader(http.StatusInternalServerError)
+			writeInternalError(writer, request, err)
 			return
 		}
 
@@ -148,7 +166,7 @@ func GetUserUrls(urlService *service.URLService) http.HandlerFunc {
 		bytes, err := json.Marshal(data)
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			writeInternalError(writer, request, err)
 			return
 		}
 
@@ -158,44 +176,58 @@ func GetUserUrls(urlService *service.URLService) http.HandlerFunc {
 	}
 }
 
-func SaveBatchURLHandler(urlService *service.URLService) http.HandlerFunc {
+func GetURLStatsHandler(statsService *service.StatsService) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		if request.Header.Get("Content-Type") != "application/json" {
-			writer.WriteHeader(http.StatusUnsupportedMediaType)
+		shortURL := chi.URLParam(request, "id")
+
+		if shortURL == "" {
+			writeProblem(writer, request, http.StatusBadRequest, CodeEmptyURL, "url is empty")
+			return
+		}
+
+		stats, err := statsService.GetStats(request.Context(), getUserID(request), shortURL, request.URL.Query().Get("bucket"))
+
+		if err != nil {
+			writeServiceError(writer, request, err)
 			return
 		}
 
-		bytes, err := io.ReadAll(request.Body)
+		bytes, err := json.Marshal(stats)
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			writeInternalError(writer, request, err)
 			return
 		}
 
-		if len(bytes) == 0 {
-			http.Error(writer, "empty body", http.StatusBadRequest)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(bytes)
+	}
+}
+
+func SaveBatchURLHandler(urlService *service.URLService) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !requireJSON(writer, request) {
 			return
 		}
 
 		var reqBody []service.URLInput
-		err = json.Unmarshal(bytes, &reqBody)
 
-		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		if !readJSON(writer, request, &reqBody) {
 			return
 		}
 
-		batchResult, err := urlService.SaveBatch(request.Context(), reqBody)
+		batchResult, err := urlService.SaveBatch(request.Context(), reqBody, getUserID(request))
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			writeServiceError(writer, request, err)
 			return
 		}
 
 		result, err := json.Marshal(batchResult)
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			writeInternalError(writer, request, err)
 			return
 		}
 
@@ -205,33 +237,153 @@ func SaveBatchURLHandler(urlService *service.URLService) http.HandlerFunc {
 	}
 }
 
-func DeleteBatchURLHandler(worker *worker.Worker) http.HandlerFunc {
+func DeleteBatchURLHandler(deleteWorker *worker.Worker) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		if request.Header.Get("Content-Type") != "application/json" {
-			writer.WriteHeader(http.StatusUnsupportedMediaType)
+		if !requireJSON(writer, request) {
 			return
 		}
 
-		bytes, err := io.ReadAll(request.Body)
+		var reqBody []string
+
+		if !readJSON(writer, request, &reqBody) {
+			return
+		}
+
+		jobID, err := deleteWorker.Process(request.Context(), getUserID(request), reqBody)
 
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			writeServiceError(writer, request, err)
 			return
 		}
 
-		if len(bytes) == 0 {
-			http.Error(writer, "empty body", http.StatusBadRequest)
+		result, err := json.Marshal(DeletionJobResponse{JobID: jobID})
+
+		if err != nil {
+			writeInternalError(writer, request, err)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusAccepted)
+		writer.Write(result)
+	}
+}
+
+func RestoreURLsHandler(urlService *service.URLService) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !requireJSON(writer, request) {
 			return
 		}
 
 		var reqBody []string
-		if err = json.Unmarshal(bytes, &reqBody); err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+
+		if !readJSON(writer, request, &reqBody) {
 			return
 		}
 
-		worker.Process(getUserID(request), reqBody)
-		writer.WriteHeader(http.StatusAccepted)
+		restoreResult, err := urlService.RestoreURLs(request.Context(), getUserID(request), reqBody)
+
+		if err != nil {
+			writeServiceError(writer, request, err)
+			return
+		}
+
+		result, err := json.Marshal(restoreResult)
+
+		if err != nil {
+			writeInternalError(writer, request, err)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(result)
+	}
+}
+
+func CreateAPIKeyHandler(apiKeyService *service.APIKeyService) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		issued, err := apiKeyService.Issue(request.Context(), getUserID(request))
+
+		if err != nil {
+			writeInternalError(writer, request, err)
+			return
+		}
+
+		result, err := json.Marshal(issued)
+
+		if err != nil {
+			writeInternalError(writer, request, err)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusCreated)
+		writer.Write(result)
+	}
+}
+
+func DeleteAPIKeyHandler(apiKeyService *service.APIKeyService) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		err := apiKeyService.Revoke(request.Context(), getUserID(request), chi.URLParam(request, "id"))
+
+		if err != nil {
+			writeServiceError(writer, request, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type DeletionJobResponse struct {
+	JobID string `json:"job_id"`
+}
+
+type DeletionJobStatusResponse struct {
+	JobID     string                   `json:"job_id"`
+	Pending   int                      `json:"pending"`
+	Completed int                      `json:"completed"`
+	Failed    int                      `json:"failed"`
+	URLs      []storage.DeletionStatus `json:"urls"`
+}
+
+func GetDeletionJobHandler(deleteWorker *worker.Worker) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		jobID := chi.URLParam(request, "jobID")
+		statuses, err := deleteWorker.JobStatus(request.Context(), getUserID(request), jobID)
+
+		if err != nil {
+			writeServiceError(writer, request, err)
+			return
+		}
+
+		response := DeletionJobStatusResponse{
+			JobID: jobID,
+			URLs:  statuses,
+		}
+
+		for _, status := range statuses {
+			switch status.Status {
+			case storage.DeletionPending:
+				response.Pending++
+			case storage.DeletionCompleted:
+				response.Completed++
+			case storage.DeletionFailed:
+				response.Failed++
+			}
+		}
+
+		result, err := json.Marshal(response)
+
+		if err != nil {
+			writeInternalError(writer, request, err)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(result)
 	}
 }
 
@@ -245,13 +397,37 @@ func getUserID(request *http.Request) string {
 	return value.ID
 }
 
+func clientIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+
+	if err != nil {
+		host = remoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+func coarseClientIP(remoteAddr string) string {
+	ip := clientIP(remoteAddr)
+
+	if ip == nil {
+		return ""
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
 func writeURLResponseRaw(writer http.ResponseWriter, shortenURL string, statusCode int) {
 	writer.WriteHeader(statusCode)
 	_, err := writer.Write([]byte(shortenURL))
 
+	// the status is already sent, so the failure can only be logged
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		return
+		log.Println(err)
 	}
 }
 
@@ -263,7 +439,8 @@ func writeURLResponseJSON(writer http.ResponseWriter, shortenURL string, statusC
 	responseBytes, serializeErr := json.Marshal(&response)
 
 	if serializeErr != nil {
-		http.Error(writer, serializeErr.Error(), http.StatusInternalServerError)
+		log.Println(serializeErr)
+		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -7,11 +7,17 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/iamsorryprincess/url-shortener/internal/middleware"
 	"github.com/iamsorryprincess/url-shortener/internal/service"
 	"github.com/iamsorryprincess/url-shortener/internal/storage"
+	"github.com/iamsorryprincess/url-shortener/internal/worker"
 )
 
 type TestHandler struct {
@@ -27,6 +33,34 @@ type TestHandler struct {
 	t           *testing.T
 }
 
+var testRedirectDefaults = RedirectDefaults{Mode: service.RedirectTemporary, InterstitialDelay: 5}
+
+// withURLParam sets the {URL} and suffix route parameters from the request path
+// the way the server router does.
+func withURLParam(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		key, suffix, _ := strings.Cut(strings.TrimPrefix(request.URL.EscapedPath(), "/"), "/")
+		routeContext := chi.NewRouteContext()
+		routeContext.URLParams.Add("URL", key)
+		routeContext.URLParams.Add("*", suffix)
+		handler(writer, request.WithContext(context.WithValue(request.Context(), chi.RouteCtxKey, routeContext)))
+	}
+}
+
+func newTestURLService(t *testing.T, urlStorage storage.Storage) *service.URLService {
+	slugGenerator, err := service.NewRandomSlugGenerator(8)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return service.NewURLService(urlStorage, "http://localhost:8080", slugGenerator, service.NewURLNormalizer([]string{"http", "https"}, 2048), nil, nil)
+}
+
+func newTestClickRecorder(clickStorage storage.ClickStorage) *worker.ClickRecorder {
+	return worker.NewClickRecorder(clickStorage, 16, 4, time.Second)
+}
+
 func testHandler(handlerTestInfo TestHandler) {
 	reqBody := []byte(handlerTestInfo.body)
 	request := httptest.NewRequest(handlerTestInfo.method, fmt.Sprintf("%s%s",
@@ -55,8 +89,7 @@ func testHandler(handlerTestInfo TestHandler) {
 }
 
 func TestJSONMakeShortURLHandler(t *testing.T) {
-	urlStorage := storage.NewInMemoryStorage()
-	urlService := service.NewURLService(urlStorage, "http://localhost:8080")
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
 
 	tests := []struct {
 		name                string
@@ -68,13 +101,13 @@ func TestJSONMakeShortURLHandler(t *testing.T) {
 			name:                "check with empty url",
 			body:                "",
 			expectedStatusCode:  400,
-			expectedContentType: "text/plain; charset=utf-8",
+			expectedContentType: problemContentType,
 		},
 		{
 			name:                "check with empty url in json body",
 			body:                `{"url": ""}`,
 			expectedStatusCode:  400,
-			expectedContentType: "text/plain; charset=utf-8",
+			expectedContentType: problemContentType,
 		},
 		{
 			name:                "check non empty url",
@@ -85,7 +118,13 @@ func TestJSONMakeShortURLHandler(t *testing.T) {
 		{
 			name:                "check same url",
 			body:                `{"url": "https://www.youtube.com/"}`,
-			expectedStatusCode:  201,
+			expectedStatusCode:  409,
+			expectedContentType: "application/json",
+		},
+		{
+			name:                "check same url in another form",
+			body:                `{"url": "HTTPS://WWW.YouTube.com:443"}`,
+			expectedStatusCode:  409,
 			expectedContentType: "application/json",
 		},
 	}
@@ -109,9 +148,59 @@ func TestJSONMakeShortURLHandler(t *testing.T) {
 	}
 }
 
+func TestJSONMakeShortURLHandlerAlias(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{
+			name:               "check valid alias",
+			body:               `{"url": "https://example.com/sale", "alias": "spring-sale"}`,
+			expectedStatusCode: 201,
+		},
+		{
+			name:               "check alias taken by another url",
+			body:               `{"url": "https://example.com/other", "alias": "spring-sale"}`,
+			expectedStatusCode: 409,
+		},
+		{
+			name:               "check reserved alias",
+			body:               `{"url": "https://example.com/api", "alias": "API"}`,
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "check alias with invalid characters",
+			body:               `{"url": "https://example.com/invalid", "alias": "spring sale!"}`,
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "check too short alias",
+			body:               `{"url": "https://example.com/short", "alias": "ab"}`,
+			expectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testInfo := TestHandler{
+				url:         "/api/shorten",
+				method:      http.MethodPost,
+				contentType: "application/json",
+				body:        test.body,
+				statusCode:  test.expectedStatusCode,
+				handler:     JSONMakeShortURLHandler(urlService),
+				t:           t,
+			}
+			testHandler(testInfo)
+		})
+	}
+}
+
 func TestRawMakeShortURLHandler(t *testing.T) {
-	urlStorage := storage.NewInMemoryStorage()
-	urlService := service.NewURLService(urlStorage, "http://localhost:8080")
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
 
 	tests := []struct {
 		name                string
@@ -123,7 +212,7 @@ func TestRawMakeShortURLHandler(t *testing.T) {
 			name:                "check with empty url",
 			body:                "",
 			expectedStatusCode:  400,
-			expectedContentType: "text/plain; charset=utf-8",
+			expectedContentType: problemContentType,
 		},
 		{
 			name:                "check non empty url",
@@ -134,7 +223,7 @@ func TestRawMakeShortURLHandler(t *testing.T) {
 		{
 			name:                "check same url",
 			body:                "https://www.youtube.com/",
-			expectedStatusCode:  201,
+			expectedStatusCode:  409,
 			expectedContentType: "",
 		},
 	}
@@ -158,10 +247,9 @@ func TestRawMakeShortURLHandler(t *testing.T) {
 }
 
 func TestGetFullURLHandler(t *testing.T) {
-	urlStorage := storage.NewInMemoryStorage()
-	urlService := service.NewURLService(urlStorage, "http://localhost:8080")
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
 	url := "https://www.youtube.com/"
-	shortURL, err := urlService.SaveURL(context.Background(), url, "test")
+	shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{OriginalURL: url}, "test")
 
 	if err != nil {
 		t.Fatal(err)
@@ -185,6 +273,12 @@ func TestGetFullURLHandler(t *testing.T) {
 			expectedStatusCode: 307,
 			locationHeader:     url,
 		},
+		{
+			name:               "test with query string",
+			query:              strings.TrimPrefix(shortURL, "http://localhost:8080/") + "?utm_source=x",
+			expectedStatusCode: 307,
+			locationHeader:     url,
+		},
 	}
 
 	for _, test := range tests {
@@ -197,7 +291,7 @@ func TestGetFullURLHandler(t *testing.T) {
 				statusCode:  test.expectedStatusCode,
 				header:      "Location",
 				headerValue: test.locationHeader,
-				handler:     GetFullURLHandler(urlService),
+				handler:     withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults)),
 				t:           t,
 			}
 			testHandler(testInfo)
@@ -205,6 +299,211 @@ func TestGetFullURLHandler(t *testing.T) {
 	}
 }
 
+func TestGetFullURLHandlerRecordsClick(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+	shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{OriginalURL: "https://www.youtube.com/"}, "test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clickStorage := storage.NewInMemoryClickStorage()
+	clickRecorder := newTestClickRecorder(clickStorage)
+	clickRecorder.Start()
+	key := strings.TrimPrefix(shortURL, "http://localhost:8080/")
+	request := httptest.NewRequest(http.MethodGet, "/"+key, nil)
+	request.Header.Set("Referer", "https://news.example.com/")
+	request.Header.Set("User-Agent", "test-agent")
+	writer := httptest.NewRecorder()
+	withURLParam(GetFullURLHandler(urlService, clickRecorder, testRedirectDefaults))(writer, request)
+	clickRecorder.Stop()
+
+	if writer.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("expected status code 307, got %d", writer.Code)
+	}
+
+	stats, err := clickStorage.GetStats(context.Background(), key, time.Time{}, storage.ClickBucketDay, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Total != 1 || stats.Visitors != 1 {
+		t.Fatalf("expected 1 recorded click, got %+v", stats)
+	}
+
+	if len(stats.TopReferrers) != 1 || stats.TopReferrers[0].Value != "https://news.example.com/" {
+		t.Errorf("unexpected referrers: %+v", stats.TopReferrers)
+	}
+
+	if len(stats.TopUserAgents) != 1 || stats.TopUserAgents[0].Value != "test-agent" {
+		t.Errorf("unexpected user agents: %+v", stats.TopUserAgents)
+	}
+}
+
+func TestGetURLStatsHandler(t *testing.T) {
+	urlStorage := storage.NewInMemoryStorage()
+	clickStorage := storage.NewInMemoryClickStorage()
+	urlService := newTestURLService(t, urlStorage)
+	statsService := service.NewStatsService(urlStorage, clickStorage, "http://localhost:8080")
+	shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{OriginalURL: "https://www.youtube.com/"}, "owner")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := strings.TrimPrefix(shortURL, "http://localhost:8080/")
+	// clicks made before the url was created belong to an earlier url with the same key
+	clickTime := time.Now().UTC().Truncate(24 * time.Hour).Add(24*time.Hour + 10*time.Hour + 30*time.Minute)
+	err = clickStorage.SaveClicks(context.Background(), []storage.Click{
+		{ShortURL: key, Timestamp: time.Now().Add(-time.Hour), Referrer: "https://old.example.com/", UserAgent: "agent-0", ClientIP: "203.0.113.0"},
+		{ShortURL: key, Timestamp: clickTime, Referrer: "https://a.example.com/", UserAgent: "agent-1", ClientIP: "192.0.2.0"},
+		{ShortURL: key, Timestamp: clickTime.Add(time.Minute), Referrer: "https://a.example.com/", UserAgent: "agent-1", ClientIP: "192.0.2.0"},
+		{ShortURL: key, Timestamp: clickTime.Add(2 * time.Hour), Referrer: "https://b.example.com/", UserAgent: "agent-2", ClientIP: "198.51.100.0"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		userID             string
+		bucket             string
+		expectedStatusCode int
+		expectedHistogram  int
+	}{
+		{
+			name:               "check owner with hour buckets",
+			userID:             "owner",
+			bucket:             "hour",
+			expectedStatusCode: http.StatusOK,
+			expectedHistogram:  2,
+		},
+		{
+			name:               "check owner with day buckets",
+			userID:             "owner",
+			expectedStatusCode: http.StatusOK,
+			expectedHistogram:  1,
+		},
+		{
+			name:               "check invalid bucket",
+			userID:             "owner",
+			bucket:             "week",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "check another user",
+			userID:             "stranger",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls/"+key+"/stats?bucket="+test.bucket, nil)
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("id", key)
+			ctx := context.WithValue(request.Context(), chi.RouteCtxKey, routeContext)
+			ctx = context.WithValue(ctx, middleware.CookieKey, middleware.UserData{ID: test.userID})
+			writer := httptest.NewRecorder()
+			GetURLStatsHandler(statsService).ServeHTTP(writer, request.WithContext(ctx))
+
+			if writer.Code != test.expectedStatusCode {
+				t.Fatalf("expected status code %d, got %d", test.expectedStatusCode, writer.Code)
+			}
+
+			if test.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			var stats service.URLStats
+
+			if err := json.Unmarshal(writer.Body.Bytes(), &stats); err != nil {
+				t.Fatal(err)
+			}
+
+			if stats.TotalClicks != 3 || stats.UniqueVisitors != 2 || len(stats.Histogram) != test.expectedHistogram {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+
+			if len(stats.TopReferrers) != 2 || stats.TopReferrers[0].Value != "https://a.example.com/" || stats.TopReferrers[0].Count != 2 {
+				t.Errorf("unexpected top referrers: %+v", stats.TopReferrers)
+			}
+		})
+	}
+}
+
+func TestGetFullURLHandlerExpired(t *testing.T) {
+	urlStorage := storage.NewInMemoryStorage()
+	urlService := newTestURLService(t, urlStorage)
+	err := urlStorage.SaveURL(context.Background(), storage.URLInput{
+		ShortURL:  "expired",
+		FullURL:   "https://www.youtube.com/",
+		UserID:    "test",
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testHandler(TestHandler{
+		url:        "/",
+		method:     http.MethodGet,
+		query:      "expired",
+		statusCode: http.StatusGone,
+		header:     "Location",
+		handler:    withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults)),
+		t:          t,
+	})
+}
+
+func TestJSONMakeShortURLHandlerExpiration(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{
+			name:               "check ttl",
+			body:               `{"url": "https://example.com/ttl", "ttl": 3600}`,
+			expectedStatusCode: 201,
+		},
+		{
+			name:               "check expires_at in the future",
+			body:               `{"url": "https://example.com/future", "expires_at": "2999-01-01T00:00:00Z"}`,
+			expectedStatusCode: 201,
+		},
+		{
+			name:               "check expires_at in the past",
+			body:               `{"url": "https://example.com/past", "expires_at": "2000-01-01T00:00:00Z"}`,
+			expectedStatusCode: 400,
+		},
+		{
+			name:               "check both ttl and expires_at",
+			body:               `{"url": "https://example.com/both", "ttl": 60, "expires_at": "2999-01-01T00:00:00Z"}`,
+			expectedStatusCode: 400,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testHandler(TestHandler{
+				url:         "/api/shorten",
+				method:      http.MethodPost,
+				contentType: "application/json",
+				body:        test.body,
+				statusCode:  test.expectedStatusCode,
+				handler:     JSONMakeShortURLHandler(urlService),
+				t:           t,
+			})
+		})
+	}
+}
+
 func TestGzipMiddleware(t *testing.T) {
 	reqBody1 := URLRequest{
 		URL: "https://practicum.yandex.ru/learn/go-developer/courses/d4f7d31d-bdf2-4d55-9845-3eb6d29448ea/sprints/21257/topics/2577c77d-8dac-4732-9d2d-48d0f9dbd57b/lessons/54bfce18-6b0e-4de4-a5bd-8a535196dff1/",
@@ -218,8 +517,7 @@ func TestGzipMiddleware(t *testing.T) {
 
 	request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(data))
 	request.Header.Set("Content-Type", "application/json")
-	urlStorage := storage.NewInMemoryStorage()
-	urlService := service.NewURLService(urlStorage, "http://localhost:8080")
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
 	handler := JSONMakeShortURLHandler(urlService)
 	writer := httptest.NewRecorder()
 	handler.ServeHTTP(writer, request)
@@ -234,3 +532,454 @@ func TestGzipMiddleware(t *testing.T) {
 		t.Error("Content-Encoding header must not contain gzip")
 	}
 }
+
+// fixedCounter makes the counter slug generator repeat the same key.
+type fixedCounter struct{}
+
+func (fixedCounter) NextCounter(ctx context.Context) (uint64, error) {
+	return 1, nil
+}
+
+func TestProblemResponses(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+
+	if _, err := urlService.SaveURL(context.Background(), service.URLInput{OriginalURL: "https://example.com/stored"}, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	exhaustedStorage := storage.NewInMemoryStorage()
+	exhaustedService := service.NewURLService(exhaustedStorage, "http://localhost:8080", service.NewCounterSlugGenerator(fixedCounter{}, 0), service.NewURLNormalizer([]string{"http", "https"}, 2048), nil, nil)
+
+	if _, err := exhaustedService.SaveURL(context.Background(), service.URLInput{OriginalURL: "https://example.com/first"}, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		handler            http.HandlerFunc
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{
+			name:               "malformed json",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": `,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidBody,
+		},
+		{
+			name:               "malformed batch",
+			handler:            SaveBatchURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"original_url": "https://example.com"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidBody,
+		},
+		{
+			name:               "unsupported content type",
+			handler:            SaveBatchURLHandler(urlService),
+			contentType:        "text/plain",
+			body:               `[]`,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+			expectedCode:       CodeUnsupportedMediaType,
+		},
+		{
+			name:               "scheme not allowed",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": "javascript:alert(1)"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeSchemeNotAllowed,
+		},
+		{
+			name:               "invalid redirect mode",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": "https://example.com/redirect", "redirect_mode": "303"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidRedirect,
+		},
+		{
+			name:               "interstitial delay without interstitial mode",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": "https://example.com/redirect", "redirect_mode": "301", "interstitial_delay": 3}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidRedirect,
+		},
+		{
+			name:               "invalid query merge",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": "https://example.com/merge", "query_merge": "replace"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidQueryMerge,
+		},
+		{
+			name:               "placeholder in host",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": "https://{shop}.example.com/", "template": true}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidTemplate,
+		},
+		{
+			name:               "rule without conditions",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": "https://example.com/app", "rules": [{"target": "https://example.com/other"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidRule,
+		},
+		{
+			name:               "invalid alias",
+			handler:            JSONMakeShortURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `{"url": "https://example.com", "alias": "a"}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       CodeInvalidAlias,
+		},
+		{
+			name:               "batch with a stored url",
+			handler:            SaveBatchURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `[{"correlation_id": "1", "original_url": "https://example.com/stored"}]`,
+			expectedStatusCode: http.StatusConflict,
+			expectedCode:       CodeURLExists,
+		},
+		{
+			name:               "batch repeating a url",
+			handler:            SaveBatchURLHandler(urlService),
+			contentType:        "application/json",
+			body:               `[{"correlation_id": "1", "original_url": "https://example.com/twice"}, {"correlation_id": "2", "original_url": "https://example.com/twice"}]`,
+			expectedStatusCode: http.StatusConflict,
+			expectedCode:       CodeURLExists,
+		},
+		{
+			name:               "slugs exhausted",
+			handler:            JSONMakeShortURLHandler(exhaustedService),
+			contentType:        "application/json",
+			body:               `{"url": "https://example.com/second"}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedCode:       CodeSlugsExhausted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", test.contentType)
+			writer := httptest.NewRecorder()
+			test.handler(writer, request)
+
+			if writer.Code != test.expectedStatusCode {
+				t.Fatalf("expected status code %d, got %d", test.expectedStatusCode, writer.Code)
+			}
+
+			if contentType := writer.Header().Get("Content-Type"); contentType != problemContentType {
+				t.Errorf("expected %s, got %s", problemContentType, contentType)
+			}
+
+			var problem Problem
+
+			if err := json.Unmarshal(writer.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+
+			if problem.Code != test.expectedCode || problem.Status != test.expectedStatusCode {
+				t.Errorf("unexpected problem: %+v", problem)
+			}
+
+			if strings.Contains(problem.Detail, "json:") || strings.Contains(problem.Detail, "unexpected end") {
+				t.Errorf("problem leaks the decoder error: %q", problem.Detail)
+			}
+		})
+	}
+}
+
+func TestDomainPolicyResponses(t *testing.T) {
+	denylistPath := filepath.Join(t.TempDir(), "denylist")
+
+	if err := os.WriteFile(denylistPath, []byte("*.evil.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := service.NewDomainPolicy(service.PolicyFiles{DenylistPath: denylistPath})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slugGenerator, err := service.NewRandomSlugGenerator(8)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urlStorage := storage.NewInMemoryStorage()
+	urlService := service.NewURLService(urlStorage, "http://localhost:8080", slugGenerator, service.NewURLNormalizer([]string{"http", "https"}, 2048), policy, nil)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://www.evil.com/"}`))
+	request.Header.Set("Content-Type", "application/json")
+	writer := httptest.NewRecorder()
+	JSONMakeShortURLHandler(urlService)(writer, request)
+
+	if writer.Code != http.StatusUnprocessableEntity || !strings.Contains(writer.Body.String(), CodeDomainBlocked) {
+		t.Fatalf("expected 422 %s, got %d %s", CodeDomainBlocked, writer.Code, writer.Body.String())
+	}
+
+	// a link created before its domain was blocked
+	err = urlStorage.SaveURL(context.Background(), storage.URLInput{FullURL: "https://login.evil.com/", ShortURL: "legacy", UserID: "test"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/legacy", nil)
+	writer = httptest.NewRecorder()
+	withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults))(writer, request)
+
+	if writer.Code != http.StatusForbidden || writer.Header().Get("Location") != "" {
+		t.Fatalf("expected 403 without a redirect, got %d %q", writer.Code, writer.Header().Get("Location"))
+	}
+}
+
+func TestGetFullURLHandlerRedirectModes(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+
+	tests := []struct {
+		name               string
+		mode               string
+		delay              int
+		defaults           RedirectDefaults
+		expectedStatusCode int
+		expectedHeader     string
+		expectedValue      string
+	}{
+		{name: "server default", defaults: testRedirectDefaults, expectedStatusCode: http.StatusTemporaryRedirect, expectedHeader: "Location", expectedValue: "https://example.com/default"},
+		{name: "changed server default", defaults: RedirectDefaults{Mode: service.RedirectFound}, expectedStatusCode: http.StatusFound, expectedHeader: "Location", expectedValue: "https://example.com/changed"},
+		{name: "moved permanently", mode: service.RedirectMovedPermanently, defaults: testRedirectDefaults, expectedStatusCode: http.StatusMovedPermanently, expectedHeader: "Location", expectedValue: "https://example.com/301"},
+		{name: "found", mode: service.RedirectFound, defaults: testRedirectDefaults, expectedStatusCode: http.StatusFound, expectedHeader: "Location", expectedValue: "https://example.com/302"},
+		{name: "permanent redirect", mode: service.RedirectPermanent, defaults: testRedirectDefaults, expectedStatusCode: http.StatusPermanentRedirect, expectedHeader: "Location", expectedValue: "https://example.com/308"},
+		{name: "interstitial", mode: service.RedirectInterstitial, delay: 2, defaults: testRedirectDefaults, expectedStatusCode: http.StatusOK, expectedHeader: "Refresh", expectedValue: "2; url=https://example.com/page"},
+		{name: "interstitial default delay", mode: service.RedirectInterstitial, defaults: testRedirectDefaults, expectedStatusCode: http.StatusOK, expectedHeader: "Refresh", expectedValue: "5; url=https://example.com/delay"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := test.expectedValue[strings.Index(test.expectedValue, "https://"):]
+			shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{
+				OriginalURL:       target,
+				RedirectMode:      test.mode,
+				InterstitialDelay: test.delay,
+			}, "test")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(shortURL, "http://localhost:8080"), nil)
+			writer := httptest.NewRecorder()
+			withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), test.defaults))(writer, request)
+
+			if writer.Code != test.expectedStatusCode {
+				t.Fatalf("expected status code %d, got %d", test.expectedStatusCode, writer.Code)
+			}
+
+			if value := writer.Header().Get(test.expectedHeader); value != test.expectedValue {
+				t.Errorf("expected %s %q, got %q", test.expectedHeader, test.expectedValue, value)
+			}
+
+			if test.mode == service.RedirectInterstitial && !strings.Contains(writer.Body.String(), `href="`+target+`"`) {
+				t.Errorf("interstitial page does not link to the target: %s", writer.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetFullURLHandlerMergesQuery(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+	shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{
+		OriginalURL: "https://example.com/landing?utm_source=partner",
+		QueryMerge:  service.QueryMergePreferTarget,
+	}, "test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(shortURL, "http://localhost:8080")+"?utm_source=x&utm_campaign=spring", nil)
+	writer := httptest.NewRecorder()
+	withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults))(writer, request)
+
+	expected := "https://example.com/landing?utm_source=partner&utm_campaign=spring"
+
+	if writer.Code != http.StatusTemporaryRedirect || writer.Header().Get("Location") != expected {
+		t.Errorf("expected 307 to %s, got %d %s", expected, writer.Code, writer.Header().Get("Location"))
+	}
+}
+
+func TestGetFullURLHandlerTemplate(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+	shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{
+		OriginalURL: "https://Shop.Example.com/{lang=en}/item?id={id}",
+		Template:    true,
+	}, "test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := strings.TrimPrefix(shortURL, "http://localhost:8080")
+	handler := withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults))
+
+	tests := []struct {
+		name               string
+		path               string
+		expectedStatusCode int
+		locationHeader     string
+	}{
+		{name: "path suffix", path: key + "/de/42", expectedStatusCode: http.StatusTemporaryRedirect, locationHeader: "https://shop.example.com/de/item?id=42"},
+		{name: "query parameters", path: key + "?id=7&lang=fr", expectedStatusCode: http.StatusTemporaryRedirect, locationHeader: "https://shop.example.com/fr/item?id=7"},
+		{name: "default value", path: key + "?id=7", expectedStatusCode: http.StatusTemporaryRedirect, locationHeader: "https://shop.example.com/en/item?id=7"},
+		{name: "escaped values", path: key + "/a%2Fb?id=1%262", expectedStatusCode: http.StatusTemporaryRedirect, locationHeader: "https://shop.example.com/a%2Fb/item?id=1%262"},
+		{name: "missing value", path: key, expectedStatusCode: http.StatusBadRequest},
+		{name: "too many segments", path: key + "/de/42/extra", expectedStatusCode: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writer := httptest.NewRecorder()
+			handler(writer, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if writer.Code != test.expectedStatusCode {
+				t.Fatalf("expected status code %d, got %d", test.expectedStatusCode, writer.Code)
+			}
+
+			if location := writer.Header().Get("Location"); location != test.locationHeader {
+				t.Errorf("expected %q, got %q", test.locationHeader, location)
+			}
+		})
+	}
+}
+
+func TestGetFullURLHandlerRules(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+	shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{
+		OriginalURL: "https://example.com/app",
+		Rules: []storage.Rule{
+			{Target: "https://apps.apple.com/app/id1", Devices: []string{service.DeviceIOS}},
+			{Target: "https://play.google.com/store/apps/details?id=app", Devices: []string{service.DeviceAndroid}},
+		},
+	}, "test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults))
+
+	tests := []struct {
+		name           string
+		userAgent      string
+		locationHeader string
+	}{
+		{name: "ios", userAgent: "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) Mobile/15E148", locationHeader: "https://apps.apple.com/app/id1"},
+		{name: "android", userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", locationHeader: "https://play.google.com/store/apps/details?id=app"},
+		{name: "everyone else", userAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", locationHeader: "https://example.com/app"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(shortURL, "http://localhost:8080"), nil)
+			request.Header.Set("User-Agent", test.userAgent)
+			writer := httptest.NewRecorder()
+			handler(writer, request)
+
+			if writer.Code != http.StatusTemporaryRedirect || writer.Header().Get("Location") != test.locationHeader {
+				t.Errorf("expected 307 to %s, got %d %s", test.locationHeader, writer.Code, writer.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestGetFullURLHandlerTemplateRules(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+	shortURL, err := urlService.SaveURL(context.Background(), service.URLInput{
+		OriginalURL: "https://example.com/{lang}/app",
+		Template:    true,
+		Rules: []storage.Rule{
+			{Target: "https://apps.apple.com/app/id1", Devices: []string{service.DeviceIOS}},
+			{Target: "https://m.example.com/{lang}", Devices: []string{service.DeviceAndroid}},
+		},
+	}, "test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults))
+
+	tests := []struct {
+		name           string
+		userAgent      string
+		locationHeader string
+	}{
+		{name: "fixed target", userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", locationHeader: "https://apps.apple.com/app/id1"},
+		{name: "template target", userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36", locationHeader: "https://m.example.com/de"},
+		{name: "link template", userAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", locationHeader: "https://example.com/de/app"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(shortURL, "http://localhost:8080")+"/de", nil)
+			request.Header.Set("User-Agent", test.userAgent)
+			writer := httptest.NewRecorder()
+			handler(writer, request)
+
+			if writer.Code != http.StatusTemporaryRedirect || writer.Header().Get("Location") != test.locationHeader {
+				t.Errorf("expected 307 to %s, got %d %s", test.locationHeader, writer.Code, writer.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestGetFullURLHandlerCacheControl(t *testing.T) {
+	urlService := newTestURLService(t, storage.NewInMemoryStorage())
+	handler := withURLParam(GetFullURLHandler(urlService, newTestClickRecorder(storage.NewInMemoryClickStorage()), testRedirectDefaults))
+
+	tests := []struct {
+		name                 string
+		input                service.URLInput
+		expectedCacheControl string
+	}{
+		{name: "plain link", input: service.URLInput{OriginalURL: "https://example.com/plain"}},
+		{name: "rules", input: service.URLInput{OriginalURL: "https://example.com/rules", Rules: []storage.Rule{{Target: "https://example.com/ios", Devices: []string{service.DeviceIOS}}}}, expectedCacheControl: "private, no-store"},
+		{name: "template", input: service.URLInput{OriginalURL: "https://example.com/{page=home}", Template: true}, expectedCacheControl: "private, no-store"},
+		{name: "query merge", input: service.URLInput{OriginalURL: "https://example.com/merge", QueryMerge: service.QueryMergePreferTarget}, expectedCacheControl: "private, no-store"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shortURL, err := urlService.SaveURL(context.Background(), test.input, "test")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			writer := httptest.NewRecorder()
+			handler(writer, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(shortURL, "http://localhost:8080"), nil))
+
+			if writer.Code != http.StatusTemporaryRedirect {
+				t.Fatalf("expected status code %d, got %d", http.StatusTemporaryRedirect, writer.Code)
+			}
+
+			if value := writer.Header().Get("Cache-Control"); value != test.expectedCacheControl {
+				t.Errorf("expected Cache-Control %q, got %q", test.expectedCacheControl, value)
+			}
+		})
+	}
+}
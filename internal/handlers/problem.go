@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/iamsorryprincess/url-shortener/internal/service"
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+	"github.com/iamsorryprincess/url-shortener/internal/worker"
+)
+
+const problemContentType = "application/problem+json"
+
+// Error codes are part of the API: clients match on them, so they must never
+// change once released. The detail text may change freely.
+const (
+	CodeInvalidBody          = "invalid_body"
+	CodeEmptyBody            = "empty_body"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeEmptyURL             = "empty_url"
+	CodeNoURLs               = "no_urls"
+	CodeInvalidURL           = "invalid_url"
+	CodeURLTooLong           = "url_too_long"
+	CodeSchemeNotAllowed     = "scheme_not_allowed"
+	CodeDomainBlocked        = "domain_blocked"
+	CodeDomainNotAllowed     = "domain_not_allowed"
+	CodeInvalidAlias         = "invalid_alias"
+	CodeReservedAlias        = "reserved_alias"
+	CodeDuplicateAlias       = "duplicate_alias"
+	CodeAliasTaken           = "alias_taken"
+	CodeURLExists            = "url_exists"
+	CodeInvalidExpiration    = "invalid_expiration"
+	CodeInvalidRedirect      = "invalid_redirect"
+	CodeInvalidQueryMerge    = "invalid_query_merge"
+	CodeInvalidTemplate      = "invalid_template"
+	CodeInvalidTemplateValue = "invalid_template_value"
+	CodeInvalidRule          = "invalid_rule"
+	CodeInvalidBucket        = "invalid_bucket"
+	CodeNotFound             = "not_found"
+	CodeGone                 = "gone"
+	CodeQueueFull            = "queue_full"
+	CodeTooManyURLs          = "too_many_urls"
+	CodeUnavailable          = "unavailable"
+	CodeSlugsExhausted       = "slugs_exhausted"
+	CodeInternal             = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details object extended with a stable code.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     string `json:"code"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func writeProblem(writer http.ResponseWriter, request *http.Request, status int, code string, detail string) {
+	bytes, err := json.Marshal(Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Code:     code,
+		Detail:   detail,
+		Instance: request.URL.Path,
+	})
+
+	if err != nil {
+		log.Println(err)
+		writer.WriteHeader(status)
+		return
+	}
+
+	writer.Header().Set("Content-Type", problemContentType)
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(status)
+	writer.Write(bytes)
+}
+
+// writeInternalError logs err and hides it from the client.
+func writeInternalError(writer http.ResponseWriter, request *http.Request, err error) {
+	log.Printf("%s %s: %v\n", request.Method, request.URL.Path, err)
+	writeProblem(writer, request, http.StatusInternalServerError, CodeInternal, "")
+}
+
+// writeServiceError maps errors returned by services to problems; errors it
+// does not know about are reported as internal errors.
+func writeServiceError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidURL):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidURL, err.Error())
+	case errors.Is(err, service.ErrURLTooLong):
+		writeProblem(writer, request, http.StatusBadRequest, CodeURLTooLong, err.Error())
+	case errors.Is(err, service.ErrSchemeNotAllowed):
+		writeProblem(writer, request, http.StatusBadRequest, CodeSchemeNotAllowed, err.Error())
+	case errors.Is(err, service.ErrDomainBlocked):
+		writeProblem(writer, request, http.StatusUnprocessableEntity, CodeDomainBlocked, err.Error())
+	case errors.Is(err, service.ErrDomainNotAllowed):
+		writeProblem(writer, request, http.StatusUnprocessableEntity, CodeDomainNotAllowed, err.Error())
+	case errors.Is(err, service.ErrInvalidAlias):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidAlias, err.Error())
+	case errors.Is(err, service.ErrReservedAlias):
+		writeProblem(writer, request, http.StatusBadRequest, CodeReservedAlias, err.Error())
+	case errors.Is(err, service.ErrDuplicateAlias):
+		writeProblem(writer, request, http.StatusBadRequest, CodeDuplicateAlias, err.Error())
+	case errors.Is(err, service.ErrInvalidExpiration):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidExpiration, err.Error())
+	case errors.Is(err, service.ErrInvalidRedirect):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidRedirect, err.Error())
+	case errors.Is(err, service.ErrInvalidQueryMerge):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidQueryMerge, err.Error())
+	case errors.Is(err, service.ErrInvalidTemplate):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidTemplate, err.Error())
+	case errors.Is(err, service.ErrInvalidTemplateValue):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidTemplateValue, err.Error())
+	case errors.Is(err, service.ErrInvalidRule):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidRule, err.Error())
+	case errors.Is(err, service.ErrAliasTaken):
+		writeProblem(writer, request, http.StatusConflict, CodeAliasTaken, err.Error())
+	case errors.Is(err, storage.ErrAlreadyExist):
+		writeProblem(writer, request, http.StatusConflict, CodeURLExists, err.Error())
+	case errors.Is(err, service.ErrSlugAttemptsExceeded):
+		writeProblem(writer, request, http.StatusServiceUnavailable, CodeSlugsExhausted, err.Error())
+	case errors.Is(err, service.ErrInvalidBucket):
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidBucket, err.Error())
+	case errors.Is(err, service.ErrURLNotOwned), errors.Is(err, storage.ErrNotFound):
+		writeProblem(writer, request, http.StatusNotFound, CodeNotFound, "")
+	case errors.Is(err, storage.ErrIsDeleted), errors.Is(err, storage.ErrIsExpired):
+		writeProblem(writer, request, http.StatusGone, CodeGone, "")
+	case errors.Is(err, worker.ErrQueueFull):
+		writer.Header().Set("Retry-After", "1")
+		writeProblem(writer, request, http.StatusTooManyRequests, CodeQueueFull, err.Error())
+	case errors.Is(err, worker.ErrNoURLs):
+		writeProblem(writer, request, http.StatusBadRequest, CodeNoURLs, err.Error())
+	case errors.Is(err, worker.ErrTooManyURLs):
+		writeProblem(writer, request, http.StatusRequestEntityTooLarge, CodeTooManyURLs, err.Error())
+	case errors.Is(err, worker.ErrWorkerStopped):
+		writeProblem(writer, request, http.StatusServiceUnavailable, CodeUnavailable, "")
+	default:
+		writeInternalError(writer, request, err)
+	}
+}
+
+// readJSON reads the request body into target and writes a problem if the body
+// is missing or is not valid JSON.
+func readJSON(writer http.ResponseWriter, request *http.Request, target interface{}) bool {
+	bytes, ok := readBody(writer, request)
+
+	if !ok {
+		return false
+	}
+
+	if err := json.Unmarshal(bytes, target); err != nil {
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidBody, "request body is not valid JSON")
+		return false
+	}
+
+	return true
+}
+
+func readBody(writer http.ResponseWriter, request *http.Request) ([]byte, bool) {
+	bytes, err := io.ReadAll(request.Body)
+
+	if err != nil {
+		writeProblem(writer, request, http.StatusBadRequest, CodeInvalidBody, "request body could not be read")
+		return nil, false
+	}
+
+	if len(bytes) == 0 {
+		writeProblem(writer, request, http.StatusBadRequest, CodeEmptyBody, "request body is empty")
+		return nil, false
+	}
+
+	return bytes, true
+}
+
+func requireJSON(writer http.ResponseWriter, request *http.Request) bool {
+	if request.Header.Get("Content-Type") != "application/json" {
+		writeProblem(writer, request, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "content type must be application/json")
+		return false
+	}
+
+	return true
+}
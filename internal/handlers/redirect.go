@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/iamsorryprincess/url-shortener/internal/service"
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+// RedirectDefaults apply to links that do not choose a redirect mode or an
+// interstitial delay of their own.
+type RedirectDefaults struct {
+	Mode              string
+	InterstitialDelay int
+}
+
+var redirectStatuses = map[string]int{
+	service.RedirectMovedPermanently: http.StatusMovedPermanently,
+	service.RedirectFound:            http.StatusFound,
+	service.RedirectTemporary:        http.StatusTemporaryRedirect,
+	service.RedirectPermanent:        http.StatusPermanentRedirect,
+}
+
+// interstitialPage works without scripts; html/template escapes the target in
+// both the refresh header value and the link.
+var interstitialPage = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Delay}};url={{.URL}}">
+<meta name="robots" content="noindex">
+<title>Redirecting</title>
+</head>
+<body>
+<p>You are being redirected to <a href="{{.URL}}" rel="noopener noreferrer">{{.URL}}</a> in {{.Delay}} seconds.</p>
+</body>
+</html>
+`))
+
+func writeRedirect(writer http.ResponseWriter, target string, options storage.LinkOptions, defaults RedirectDefaults) {
+	mode := options.RedirectMode
+
+	if mode == "" {
+		mode = defaults.Mode
+	}
+
+	// the target of these links depends on the request, so a shared cache must
+	// not replay one visitor's redirect to another
+	if isPerRequest(options) {
+		writer.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	if mode != service.RedirectInterstitial {
+		status, ok := redirectStatuses[mode]
+
+		if !ok {
+			status = http.StatusTemporaryRedirect
+		}
+
+		writer.Header().Set("Location", target)
+		writer.WriteHeader(status)
+		return
+	}
+
+	delay := options.InterstitialDelay
+
+	if delay == 0 {
+		delay = defaults.InterstitialDelay
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Content-Security-Policy", "default-src 'none'")
+	writer.Header().Set("Refresh", strconv.Itoa(delay)+"; url="+target)
+	writer.WriteHeader(http.StatusOK)
+	err := interstitialPage.Execute(writer, struct {
+		URL   string
+		Delay int
+	}{
+		URL:   target,
+		Delay: delay,
+	})
+
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// isPerRequest reports whether the target of the link is resolved from the
+// visitor or from the path and the query of the redirect request.
+func isPerRequest(options storage.LinkOptions) bool {
+	return len(options.Rules) > 0 || options.Template || options.QueryMerge != ""
+}
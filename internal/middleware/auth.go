@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+	"github.com/iamsorryprincess/url-shortener/pkg/hash"
+)
+
+type cookieUserKey string
+
+const cookieName = "user_data"
+const CookieKey = cookieUserKey(cookieName)
+
+const apiKeyHeader = "X-API-Key"
+const bearerPrefix = "Bearer "
+
+// InvalidCookiePolicy decides what happens to a request whose user_data cookie
+// cannot be decoded, e.g. after the signing keys were rotated out.
+type InvalidCookiePolicy string
+
+const (
+	// IssueNewIdentity replaces the cookie with a new user identity.
+	IssueNewIdentity InvalidCookiePolicy = "issue"
+	// RejectUnauthorized responds 401 so that the client notices it lost access
+	// to its urls instead of silently seeing an empty list.
+	RejectUnauthorized InvalidCookiePolicy = "reject"
+)
+
+// CookieOptions are the attributes of the user_data cookie; a zero MaxAge makes
+// it a session cookie.
+type CookieOptions struct {
+	HTTPOnly bool
+	Secure   bool
+	SameSite http.SameSite
+	MaxAge   time.Duration
+}
+
+type UserData struct {
+	ID string
+}
+
+// APIKeyAuthenticator returns storage.ErrInvalidAPIKey for keys that do not
+// identify a user.
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (string, error)
+}
+
+// Auth identifies the user by a bearer token, an API key or the user_data
+// cookie, in that order. Bearer tokens are the same tokens the cookie holds.
+// Clients without credentials are given a new identity in a cookie, clients
+// with an invalid cookie are handled according to policy and a request carrying
+// an invalid bearer token or API key is always rejected.
+func Auth(
+	keyManager hash.KeyManager,
+	authenticator APIKeyAuthenticator,
+	cookieOptions CookieOptions,
+	policy InvalidCookiePolicy) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if authorization := request.Header.Get("Authorization"); authorization != "" {
+				if !strings.HasPrefix(authorization, bearerPrefix) {
+					writeProblem(writer, request, http.StatusUnauthorized, codeUnauthorized, "unsupported authorization scheme")
+					return
+				}
+
+				userID, err := keyManager.Decode(strings.TrimPrefix(authorization, bearerPrefix))
+
+				if err != nil {
+					writeProblem(writer, request, http.StatusUnauthorized, codeUnauthorized, "invalid token")
+					return
+				}
+
+				serveWithUser(next, writer, request, userID)
+				return
+			}
+
+			if key := request.Header.Get(apiKeyHeader); key != "" {
+				userID, err := authenticator.Authenticate(request.Context(), key)
+
+				if err != nil {
+					if errors.Is(err, storage.ErrInvalidAPIKey) {
+						writeProblem(writer, request, http.StatusUnauthorized, codeUnauthorized, err.Error())
+						return
+					}
+					log.Println(err)
+					writeProblem(writer, request, http.StatusInternalServerError, codeInternal, "")
+					return
+				}
+
+				serveWithUser(next, writer, request, userID)
+				return
+			}
+
+			cookie, err := request.Cookie(cookieName)
+
+			if err == nil {
+				userID, decodeErr := keyManager.Decode(cookie.Value)
+
+				if decodeErr == nil {
+					serveWithUser(next, writer, request, userID)
+					return
+				}
+
+				if policy != IssueNewIdentity {
+					writeProblem(writer, request, http.StatusUnauthorized, codeUnauthorized, "invalid user cookie")
+					return
+				}
+			} else if !errors.Is(err, http.ErrNoCookie) {
+				log.Println(err)
+				writeProblem(writer, request, http.StatusUnauthorized, codeUnauthorized, "invalid user cookie")
+				return
+			}
+
+			userID := uuid.New().String()
+			token, err := keyManager.Encode(userID)
+
+			if err != nil {
+				log.Println(err)
+				writeProblem(writer, request, http.StatusInternalServerError, codeInternal, "")
+				return
+			}
+
+			http.SetCookie(writer, &http.Cookie{
+				Name:     cookieName,
+				Value:    token,
+				Path:     "/",
+				MaxAge:   int(cookieOptions.MaxAge.Seconds()),
+				HttpOnly: cookieOptions.HTTPOnly,
+				Secure:   cookieOptions.Secure,
+				SameSite: cookieOptions.SameSite,
+			})
+			serveWithUser(next, writer, request, userID)
+		})
+	}
+}
+
+func serveWithUser(next http.Handler, writer http.ResponseWriter, request *http.Request, userID string) {
+	next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), CookieKey, UserData{
+		ID: userID,
+	})))
+}
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/service"
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+	"github.com/iamsorryprincess/url-shortener/pkg/hash"
+)
+
+func newTestKeyManager(t *testing.T) hash.KeyManager {
+	t.Helper()
+	key, err := hash.GenerateKey("test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyManager, err := hash.NewGcmKeyManager([]hash.Key{key}, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyManager
+}
+
+var testCookieOptions = CookieOptions{
+	HTTPOnly: true,
+	Secure:   true,
+	SameSite: http.SameSiteStrictMode,
+	MaxAge:   time.Hour,
+}
+
+func TestAuth(t *testing.T) {
+	ctx := context.Background()
+	keyManager := newTestKeyManager(t)
+	apiKeyService := service.NewAPIKeyService(storage.NewInMemoryAPIKeyStorage())
+
+	token, err := keyManager.Encode("token-user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiKey, err := apiKeyService.Issue(ctx, "key-user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revokedKey, err := apiKeyService.Issue(ctx, "key-user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = apiKeyService.Revoke(ctx, "key-user", revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		code      int
+		userID    string
+		setCookie bool
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		cookie  *http.Cookie
+		policy  InvalidCookiePolicy
+		want    want
+	}{
+		{
+			name:    "bearer token",
+			headers: map[string]string{"Authorization": "Bearer " + token},
+			want:    want{code: http.StatusOK, userID: "token-user"},
+		},
+		{
+			name:    "invalid bearer token",
+			headers: map[string]string{"Authorization": "Bearer garbage"},
+			want:    want{code: http.StatusUnauthorized},
+		},
+		{
+			name:    "unsupported scheme",
+			headers: map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+			want:    want{code: http.StatusUnauthorized},
+		},
+		{
+			name:    "api key",
+			headers: map[string]string{"X-API-Key": apiKey.Key},
+			want:    want{code: http.StatusOK, userID: "key-user"},
+		},
+		{
+			name:    "revoked api key",
+			headers: map[string]string{"X-API-Key": revokedKey.Key},
+			want:    want{code: http.StatusUnauthorized},
+		},
+		{
+			name:   "cookie",
+			cookie: &http.Cookie{Name: cookieName, Value: token},
+			want:   want{code: http.StatusOK, userID: "token-user"},
+		},
+		{
+			name: "new cookie",
+			want: want{code: http.StatusOK, setCookie: true},
+		},
+		{
+			name:   "invalid cookie is replaced",
+			cookie: &http.Cookie{Name: cookieName, Value: "garbage"},
+			policy: IssueNewIdentity,
+			want:   want{code: http.StatusOK, setCookie: true},
+		},
+		{
+			name:   "invalid cookie is rejected",
+			cookie: &http.Cookie{Name: cookieName, Value: "garbage"},
+			policy: RejectUnauthorized,
+			want:   want{code: http.StatusUnauthorized},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userID := ""
+			handler := Auth(keyManager, apiKeyService, testCookieOptions, test.policy)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				userID = request.Context().Value(CookieKey).(UserData).ID
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+
+			if test.cookie != nil {
+				request.AddCookie(test.cookie)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			defer result.Body.Close()
+
+			if result.StatusCode != test.want.code {
+				t.Fatalf("expected status %d, got %d", test.want.code, result.StatusCode)
+			}
+
+			if result.StatusCode == http.StatusUnauthorized && result.Header.Get("Content-Type") != "application/problem+json" {
+				t.Errorf("expected a json error body, got %s", result.Header.Get("Content-Type"))
+			}
+
+			if test.want.userID != "" && userID != test.want.userID {
+				t.Errorf("expected user %q, got %q", test.want.userID, userID)
+			}
+
+			cookies := result.Cookies()
+
+			if setCookie := len(cookies) > 0; setCookie != test.want.setCookie {
+				t.Fatalf("expected cookie to be set: %v, got %v", test.want.setCookie, setCookie)
+			}
+
+			for _, cookie := range cookies {
+				if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 3600 {
+					t.Errorf("cookie attributes do not match the options: %v", cookie)
+				}
+			}
+		})
+	}
+}
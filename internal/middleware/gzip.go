@@ -3,6 +3,7 @@ package middleware
 import (
 	"compress/gzip"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 )
@@ -24,7 +25,7 @@ func Gzip(next http.Handler) http.Handler {
 			reader, err := gzip.NewReader(request.Body)
 
 			if err != nil {
-				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				writeProblem(writer, request, http.StatusBadRequest, codeInvalidBody, "request body is not valid gzip")
 				return
 			}
 
@@ -36,7 +37,8 @@ func Gzip(next http.Handler) http.Handler {
 			gz, err := gzip.NewWriterLevel(writer, gzip.BestSpeed)
 
 			if err != nil {
-				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				log.Println(err)
+				writeProblem(writer, request, http.StatusInternalServerError, codeInternal, "")
 				return
 			}
 
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	codeUnauthorized = "unauthorized"
+	codeInvalidBody  = "invalid_body"
+	codeInternal     = "internal_error"
+)
+
+// problem mirrors handlers.Problem; middleware cannot import handlers, which
+// depends on it for the user identity.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     string `json:"code"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func writeProblem(writer http.ResponseWriter, request *http.Request, status int, code string, detail string) {
+	bytes, _ := json.Marshal(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Code:     code,
+		Detail:   detail,
+		Instance: request.URL.Path,
+	})
+	writer.Header().Set("Content-Type", "application/problem+json")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(status)
+	writer.Write(bytes)
+}
@@ -23,22 +23,33 @@ type Server struct {
 func NewServer(
 	configuration *config.Configuration,
 	service *service.URLService,
+	statsService *service.StatsService,
+	apiKeyService *service.APIKeyService,
 	keyManager hash.KeyManager,
 	db *sql.DB,
-	worker *worker.Worker) *Server {
+	worker *worker.Worker,
+	clickRecorder *worker.ClickRecorder) *Server {
 	r := chi.NewRouter()
 
 	r.Use(chimiddleware.Logger)
 	r.Use(chimiddleware.Recoverer)
 	r.Use(middleware.Gzip)
-	r.Use(middleware.Cookie(keyManager))
 
-	r.Post("/", handlers.RawMakeShortURLHandler(service))
-	r.Post("/api/shorten", handlers.JSONMakeShortURLHandler(service))
-	r.Post("/api/shorten/batch", handlers.SaveBatchURLHandler(service))
-	r.Get("/{URL}", handlers.GetFullURLHandler(service))
-	r.Get("/api/user/urls", handlers.GetUserUrls(service))
-	r.Delete("/api/user/urls", handlers.DeleteBatchURLHandler(worker))
+	cookieOptions := middleware.CookieOptions{
+		HTTPOnly: configuration.CookieHTTPOnly,
+		Secure:   configuration.CookieSecure,
+		SameSite: sameSiteMode(configuration.CookieSameSite),
+		MaxAge:   configuration.CookieMaxAge,
+	}
+
+	// redirects and ping do not need an identity, so they never set a cookie
+	// and stay cacheable
+	redirectHandler := handlers.GetFullURLHandler(service, clickRecorder, handlers.RedirectDefaults{
+		Mode:              configuration.RedirectMode,
+		InterstitialDelay: configuration.InterstitialDelay,
+	})
+	r.Get("/{URL}", redirectHandler)
+	r.Get("/{URL}/*", redirectHandler)
 
 	if configuration.DBConnectionString != "" {
 		r.Get("/ping", func(writer http.ResponseWriter, request *http.Request) {
@@ -54,6 +65,26 @@ func NewServer(
 		})
 	}
 
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Auth(keyManager, apiKeyService, cookieOptions, middleware.InvalidCookiePolicy(configuration.InvalidCookiePolicy)))
+
+		r.Post("/", handlers.RawMakeShortURLHandler(service))
+		r.Post("/api/shorten", handlers.JSONMakeShortURLHandler(service))
+		r.Post("/api/shorten/batch", handlers.SaveBatchURLHandler(service))
+	})
+
+	r.Route("/api/user", func(r chi.Router) {
+		r.Use(middleware.Auth(keyManager, apiKeyService, cookieOptions, middleware.InvalidCookiePolicy(configuration.UserInvalidCookiePolicy)))
+
+		r.Get("/urls", handlers.GetUserUrls(service))
+		r.Get("/urls/{id}/stats", handlers.GetURLStatsHandler(statsService))
+		r.Delete("/urls", handlers.DeleteBatchURLHandler(worker))
+		r.Post("/urls/restore", handlers.RestoreURLsHandler(service))
+		r.Get("/deletions/{jobID}", handlers.GetDeletionJobHandler(worker))
+		r.Post("/keys", handlers.CreateAPIKeyHandler(apiKeyService))
+		r.Delete("/keys/{id}", handlers.DeleteAPIKeyHandler(apiKeyService))
+	})
+
 	return &Server{
 		httpServer: &http.Server{
 			Addr:    configuration.Address,
@@ -62,6 +93,17 @@ func NewServer(
 	}
 }
 
+func sameSiteMode(value string) http.SameSite {
+	switch value {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
 func (s *Server) Run() error {
 	return s.httpServer.ListenAndServe()
 }
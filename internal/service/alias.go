@@ -0,0 +1,74 @@
+package service
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 64
+)
+
+var ErrInvalidAlias = errors.New("alias must be 3-64 characters long and contain only letters, digits, '-' or '_'")
+var ErrReservedAlias = errors.New("alias is reserved")
+var ErrDuplicateAlias = errors.New("alias is used more than once in batch")
+var ErrAliasTaken = errors.New("alias is already taken")
+
+// reservedAliases are the first path segments of the static routes; generated
+// keys are checked against them as well.
+var reservedAliases = map[string]bool{
+	"api":  true,
+	"ping": true,
+}
+
+func isReservedKey(key string) bool {
+	return reservedAliases[strings.ToLower(key)]
+}
+
+func validateAlias(alias string) error {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return ErrInvalidAlias
+	}
+
+	for _, r := range alias {
+		if !isAliasRune(r) {
+			return ErrInvalidAlias
+		}
+	}
+
+	if isReservedKey(alias) {
+		return ErrReservedAlias
+	}
+
+	return nil
+}
+
+func validateBatchAliases(input []URLInput) error {
+	seen := make(map[string]bool)
+
+	for _, item := range input {
+		if item.Alias == "" {
+			continue
+		}
+
+		if err := validateAlias(item.Alias); err != nil {
+			return err
+		}
+
+		if seen[item.Alias] {
+			return ErrDuplicateAlias
+		}
+
+		seen[item.Alias] = true
+	}
+
+	return nil
+}
+
+func isAliasRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') ||
+		(r >= 'A' && r <= 'Z') ||
+		(r >= '0' && r <= '9') ||
+		r == '-' || r == '_'
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+const apiKeyPrefix = "sk_"
+
+type APIKeyService struct {
+	storage storage.APIKeyStorage
+}
+
+func NewAPIKeyService(apiKeyStorage storage.APIKeyStorage) *APIKeyService {
+	return &APIKeyService{
+		storage: apiKeyStorage,
+	}
+}
+
+type IssuedAPIKey struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Issue creates a key for the user; only its hash is stored, so the returned
+// key cannot be shown again.
+func (service *APIKeyService) Issue(ctx context.Context, userID string) (*IssuedAPIKey, error) {
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	issued := &IssuedAPIKey{
+		ID:        uuid.New().String(),
+		Key:       apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err := service.storage.SaveAPIKey(ctx, storage.APIKey{
+		ID:        issued.ID,
+		UserID:    userID,
+		Hash:      hashAPIKey(issued.Key),
+		CreatedAt: issued.CreatedAt,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return issued, nil
+}
+
+// Authenticate returns the id of the user the key was issued to.
+func (service *APIKeyService) Authenticate(ctx context.Context, key string) (string, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", storage.ErrInvalidAPIKey
+	}
+
+	userID, err := service.storage.GetUserIDByAPIKeyHash(ctx, hashAPIKey(key))
+
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", storage.ErrInvalidAPIKey
+		}
+		return "", err
+	}
+
+	return userID, nil
+}
+
+func (service *APIKeyService) Revoke(ctx context.Context, userID string, id string) error {
+	return service.storage.RevokeAPIKey(ctx, userID, id)
+}
+
+// hashAPIKey does not need a salt or a slow hash: keys are 256 bit random values,
+// not user chosen passwords.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
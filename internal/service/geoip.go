@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GeoIPDatabase maps ip ranges to ISO 3166-1 alpha-2 country codes. Ranges
+// must not overlap.
+type GeoIPDatabase struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	first   net.IP
+	last    net.IP
+	country string
+}
+
+// LoadGeoIPDatabase reads a CSV file with one range per line, either
+// "network,country" with a CIDR network or "first_ip,last_ip,country". Lines
+// starting with # and a header line are skipped.
+func LoadGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	database := &GeoIPDatabase{}
+
+	for lineNumber := 1; ; lineNumber++ {
+		record, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := parseIPRange(record)
+
+		if err != nil {
+			if lineNumber == 1 {
+				continue
+			}
+
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+
+		database.ranges = append(database.ranges, entry)
+	}
+
+	sort.Slice(database.ranges, func(i, j int) bool {
+		return bytes.Compare(database.ranges[i].first, database.ranges[j].first) < 0
+	})
+
+	return database, nil
+}
+
+func parseIPRange(record []string) (ipRange, error) {
+	switch len(record) {
+	case 2:
+		_, network, err := net.ParseCIDR(record[0])
+
+		if err != nil {
+			return ipRange{}, err
+		}
+
+		first := network.IP.To16()
+		last := make(net.IP, len(first))
+		mask := network.Mask
+
+		// a v4 mask covers only the last four bytes of the 16 byte form
+		offset := len(first) - len(mask)
+
+		for i := range first {
+			last[i] = first[i]
+
+			if i >= offset {
+				last[i] |= ^mask[i-offset]
+			}
+		}
+
+		return ipRange{first: first, last: last, country: strings.ToUpper(record[1])}, nil
+	case 3:
+		first, last := net.ParseIP(record[0]), net.ParseIP(record[1])
+
+		if first == nil || last == nil || bytes.Compare(first.To16(), last.To16()) > 0 {
+			return ipRange{}, fmt.Errorf("invalid range %s-%s", record[0], record[1])
+		}
+
+		return ipRange{first: first.To16(), last: last.To16(), country: strings.ToUpper(record[2])}, nil
+	default:
+		return ipRange{}, fmt.Errorf("expected 2 or 3 fields, got %d", len(record))
+	}
+}
+
+// Country returns the country of ip or an empty string if it is unknown.
+func (d *GeoIPDatabase) Country(ip net.IP) string {
+	ip = ip.To16()
+
+	if d == nil || ip == nil {
+		return ""
+	}
+
+	index := sort.Search(len(d.ranges), func(i int) bool {
+		return bytes.Compare(d.ranges[i].first, ip) > 0
+	}) - 1
+
+	if index < 0 || bytes.Compare(ip, d.ranges[index].last) > 0 {
+		return ""
+	}
+
+	return d.ranges[index].country
+}
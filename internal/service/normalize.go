@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidURL = errors.New("invalid url")
+var ErrURLTooLong = errors.New("url is too long")
+var ErrSchemeNotAllowed = errors.New("url scheme is not allowed")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URLNormalizer validates original urls and brings them to a canonical form, so
+// that urls differing only in letter case of the scheme and host, a default port
+// or an empty path are stored once.
+type URLNormalizer struct {
+	schemes   map[string]bool
+	maxLength int
+}
+
+func NewURLNormalizer(schemes []string, maxLength int) *URLNormalizer {
+	allowed := make(map[string]bool, len(schemes))
+
+	for _, scheme := range schemes {
+		allowed[strings.ToLower(strings.TrimSpace(scheme))] = true
+	}
+
+	return &URLNormalizer{
+		schemes:   allowed,
+		maxLength: maxLength,
+	}
+}
+
+func (n *URLNormalizer) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+
+	if rawURL == "" {
+		return "", fmt.Errorf("%w: url is empty", ErrInvalidURL)
+	}
+
+	if len(rawURL) > n.maxLength {
+		return "", fmt.Errorf("%w: at most %d characters are allowed", ErrURLTooLong, n.maxLength)
+	}
+
+	if strings.IndexFunc(rawURL, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) >= 0 {
+		return "", fmt.Errorf("%w: url contains whitespace or control characters", ErrInvalidURL)
+	}
+
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil {
+		return "", fmt.Errorf("%w: url cannot be parsed", ErrInvalidURL)
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+
+	if !n.schemes[parsed.Scheme] {
+		return "", fmt.Errorf("%w: %q", ErrSchemeNotAllowed, parsed.Scheme)
+	}
+
+	// credentials in urls are a common phishing trick: http://bank.com@evil.com
+	if parsed.User != nil {
+		return "", fmt.Errorf("%w: credentials are not allowed", ErrInvalidURL)
+	}
+
+	host, err := normalizeHost(parsed.Hostname())
+
+	if err != nil {
+		return "", err
+	}
+
+	port := parsed.Port()
+
+	if port == defaultPorts[parsed.Scheme] {
+		port = ""
+	}
+
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	if port != "" {
+		host = host + ":" + port
+	}
+
+	parsed.Host = host
+
+	if parsed.Path == "" && parsed.RawPath == "" {
+		parsed.Path = "/"
+	}
+
+	result := parsed.String()
+
+	if len(result) > n.maxLength {
+		return "", fmt.Errorf("%w: at most %d characters are allowed", ErrURLTooLong, n.maxLength)
+	}
+
+	return result, nil
+}
+
+// normalizeHost lowercases the host and converts internationalized labels to
+// punycode.
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("%w: host is empty", ErrInvalidURL)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("%w: host contains an empty label", ErrInvalidURL)
+		}
+
+		if !isASCII(label) {
+			encoded, err := punycodeEncode(label)
+
+			if err != nil {
+				return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+			}
+
+			label = "xn--" + encoded
+		}
+
+		if len(label) > 63 || !isHostLabel(label) {
+			return "", fmt.Errorf("%w: invalid host label %q", ErrInvalidURL, label)
+		}
+
+		labels[i] = label
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isHostLabel allows underscores in addition to letters, digits and hyphens as
+// they are common in real world hostnames.
+func isHostLabel(label string) bool {
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return false
+	}
+
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
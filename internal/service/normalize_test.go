@@ -0,0 +1,50 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestURLNormalizer(t *testing.T) {
+	normalizer := NewURLNormalizer([]string{"http", "https"}, 64)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "already normalized", input: "https://example.com/path?q=1#top", want: "https://example.com/path?q=1#top"},
+		{name: "case of scheme and host", input: "HTTP://Example.COM/Path", want: "http://example.com/Path"},
+		{name: "empty path", input: "http://example.com", want: "http://example.com/"},
+		{name: "surrounding whitespace", input: "  https://example.com/  ", want: "https://example.com/"},
+		{name: "default http port", input: "http://example.com:80/", want: "http://example.com/"},
+		{name: "default https port", input: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "non default port", input: "https://example.com:8443/", want: "https://example.com:8443/"},
+		{name: "idn host", input: "https://Bücher.example/", want: "https://xn--bcher-kva.example/"},
+		{name: "cyrillic host", input: "http://пример.рф/", want: "http://xn--e1afmkfd.xn--p1ai/"},
+		{name: "ipv6 host", input: "http://[::1]:80/", want: "http://[::1]/"},
+		{name: "javascript scheme", input: "javascript:alert(1)", wantErr: ErrSchemeNotAllowed},
+		{name: "relative url", input: "example.com/path", wantErr: ErrSchemeNotAllowed},
+		{name: "whitespace inside", input: "https://exa mple.com/", wantErr: ErrInvalidURL},
+		{name: "empty", input: "   ", wantErr: ErrInvalidURL},
+		{name: "credentials", input: "https://bank.com@evil.com/", wantErr: ErrInvalidURL},
+		{name: "no host", input: "https:///path", wantErr: ErrInvalidURL},
+		{name: "too long", input: "https://example.com/" + strings.Repeat("a", 64), wantErr: ErrURLTooLong},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := normalizer.Normalize(test.input)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDomainBlocked = errors.New("domain is blocked")
+var ErrDomainNotAllowed = errors.New("domain is not in the allowlist")
+
+// PolicyFiles are the sources of a DomainPolicy; an empty path disables the list.
+//
+// Allow and deny lists hold one pattern per line: "example.com" matches only
+// that host and "*.example.com" matches its subdomains. The blocklist feed uses
+// the hosts file ("0.0.0.0 evil.com") or adblock ("||evil.com^") formats or
+// plain domains, and every entry also blocks the subdomains of the domain;
+// "*.evil.com" blocks only the subdomains. Adblock modifiers after ^ are
+// ignored. Feed lines that hold no valid domain are logged and skipped, while
+// a single invalid pattern rejects an allow or deny list. Lines starting with
+// # are comments in all files.
+type PolicyFiles struct {
+	AllowlistPath string
+	DenylistPath  string
+	BlocklistPath string
+}
+
+// DomainPolicy decides which hosts may be shortened. When the allowlist is not
+// empty only matching hosts are accepted; the deny list and the blocklist feed
+// always win over the allowlist.
+type DomainPolicy struct {
+	files    PolicyFiles
+	mutex    sync.RWMutex
+	rules    policyRules
+	modTimes map[string]time.Time
+	loaded   bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type policyRules struct {
+	allow     domainMatcher
+	deny      domainMatcher
+	blocklist domainMatcher
+}
+
+// NewDomainPolicy loads the files once; Start keeps them up to date.
+func NewDomainPolicy(files PolicyFiles) (*DomainPolicy, error) {
+	policy := &DomainPolicy{
+		files:    files,
+		modTimes: make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if _, err := policy.reload(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Start reloads the files every interval if any of them changed. A file that
+// fails to load keeps the previous rules in effect.
+func (p *DomainPolicy) Start(interval time.Duration) {
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				reloaded, err := p.reload()
+
+				if err != nil {
+					log.Println(err)
+				} else if reloaded {
+					log.Println("policy: domain lists reloaded")
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *DomainPolicy) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// Check reports whether urls pointing to rawURL may be created.
+func (p *DomainPolicy) Check(rawURL string) error {
+	host := hostOf(rawURL)
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.rules.deny.match(host) || p.rules.blocklist.match(host) {
+		return fmt.Errorf("%w: %s", ErrDomainBlocked, host)
+	}
+
+	if !p.rules.allow.empty() && !p.rules.allow.match(host) {
+		return fmt.Errorf("%w: %s", ErrDomainNotAllowed, host)
+	}
+
+	return nil
+}
+
+// CheckRedirect reports whether an existing url may still redirect to rawURL.
+// Only the deny list and the blocklist apply, so that narrowing the allowlist
+// does not break links that were already handed out.
+func (p *DomainPolicy) CheckRedirect(rawURL string) error {
+	host := hostOf(rawURL)
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.rules.deny.match(host) || p.rules.blocklist.match(host) {
+		return fmt.Errorf("%w: %s", ErrDomainBlocked, host)
+	}
+
+	return nil
+}
+
+func (p *DomainPolicy) reload() (bool, error) {
+	changed := false
+	modTimes := make(map[string]time.Time)
+
+	for _, path := range []string{p.files.AllowlistPath, p.files.DenylistPath, p.files.BlocklistPath} {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return false, err
+		}
+
+		modTimes[path] = info.ModTime()
+
+		if !info.ModTime().Equal(p.modTimes[path]) {
+			changed = true
+		}
+	}
+
+	if !changed && p.loaded {
+		return false, nil
+	}
+
+	var rules policyRules
+	var err error
+
+	if rules.allow, err = loadDomainList(p.files.AllowlistPath, parsePattern, false); err != nil {
+		return false, err
+	}
+
+	if rules.deny, err = loadDomainList(p.files.DenylistPath, parsePattern, false); err != nil {
+		return false, err
+	}
+
+	if rules.blocklist, err = loadDomainList(p.files.BlocklistPath, parseFeedEntry, true); err != nil {
+		return false, err
+	}
+
+	p.mutex.Lock()
+	p.rules = rules
+	p.modTimes = modTimes
+	p.loaded = true
+	p.mutex.Unlock()
+	return true, nil
+}
+
+// domainMatcher matches hosts exactly or, for suffix entries, any subdomain.
+type domainMatcher struct {
+	exact    map[string]bool
+	suffixes map[string]bool
+}
+
+func (m domainMatcher) empty() bool {
+	return len(m.exact) == 0 && len(m.suffixes) == 0
+}
+
+func (m domainMatcher) match(host string) bool {
+	if host == "" {
+		return false
+	}
+
+	if m.exact[host] {
+		return true
+	}
+
+	for index := strings.IndexByte(host, '.'); index >= 0; index = strings.IndexByte(host, '.') {
+		host = host[index+1:]
+
+		if m.suffixes[host] {
+			return true
+		}
+	}
+
+	return false
+}
+
+type matchKind int
+
+const (
+	matchHost matchKind = iota
+	matchSubdomains
+	matchHostAndSubdomains
+)
+
+// entryParser returns the domain of a line and what it matches, or false if the
+// line holds no entry.
+type entryParser func(line string) (domain string, kind matchKind, ok bool)
+
+// loadDomainList fails on the first invalid entry unless skipInvalid is set:
+// third-party feeds are not under our control and one bad line must not drop
+// the whole feed.
+func loadDomainList(path string, parse entryParser, skipInvalid bool) (domainMatcher, error) {
+	matcher := domainMatcher{
+		exact:    make(map[string]bool),
+		suffixes: make(map[string]bool),
+	}
+
+	if path == "" {
+		return matcher, nil
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return matcher, err
+	}
+
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	skipped := 0
+	var firstSkipped error
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		domain, kind, ok := parse(line)
+
+		if !ok {
+			continue
+		}
+
+		domain, err = normalizeHost(domain)
+
+		if err != nil {
+			err = fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+
+			if !skipInvalid {
+				return matcher, err
+			}
+
+			if skipped == 0 {
+				firstSkipped = err
+			}
+
+			skipped++
+			continue
+		}
+
+		if kind != matchSubdomains {
+			matcher.exact[domain] = true
+		}
+
+		if kind != matchHost {
+			matcher.suffixes[domain] = true
+		}
+	}
+
+	if skipped > 0 {
+		log.Printf("policy: skipped %d invalid entries, the first one at %v\n", skipped, firstSkipped)
+	}
+
+	return matcher, scanner.Err()
+}
+
+func parsePattern(line string) (string, matchKind, bool) {
+	if strings.HasPrefix(line, "*.") {
+		return line[2:], matchSubdomains, true
+	}
+
+	return line, matchHost, true
+}
+
+func parseFeedEntry(line string) (string, matchKind, bool) {
+	// exception rules unblock what other rules block; the feed only blocks
+	if strings.HasPrefix(line, "@@") {
+		return "", matchHost, false
+	}
+
+	if strings.HasPrefix(line, "||") {
+		domain := strings.TrimPrefix(line, "||")
+
+		// modifiers such as $third-party follow the ^ separator
+		if index := strings.IndexAny(domain, "^$"); index >= 0 {
+			domain = domain[:index]
+		}
+
+		return feedDomain(domain)
+	}
+
+	fields := strings.Fields(line)
+
+	switch {
+	case len(fields) == 1:
+		return feedDomain(fields[0])
+	case len(fields) >= 2 && (fields[0] == "0.0.0.0" || fields[0] == "127.0.0.1"):
+		if fields[1] == "localhost" || strings.HasPrefix(fields[1], "#") {
+			return "", matchHost, false
+		}
+		return feedDomain(fields[1])
+	default:
+		return "", matchHost, false
+	}
+}
+
+func feedDomain(domain string) (string, matchKind, bool) {
+	if strings.HasPrefix(domain, "*.") {
+		return domain[2:], matchSubdomains, domain != "*."
+	}
+
+	return domain, matchHostAndSubdomains, domain != ""
+}
+
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
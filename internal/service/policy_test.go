@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePolicyFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDomainPolicy(t *testing.T) {
+	dir := t.TempDir()
+	files := PolicyFiles{
+		AllowlistPath: filepath.Join(dir, "allowlist"),
+		DenylistPath:  filepath.Join(dir, "denylist"),
+		BlocklistPath: filepath.Join(dir, "feed"),
+	}
+	writePolicyFile(t, files.AllowlistPath, "# partners\nexample.com\n*.example.org\nbücher.example\n")
+	writePolicyFile(t, files.DenylistPath, "ads.example.org\n")
+	writePolicyFile(t, files.BlocklistPath, "! adblock comment\n0.0.0.0 localhost\n0.0.0.0 example.com # bad\n||phish.example.org^\n127.0.0.1 malware.example.org\n"+
+		"||tracker.example.org^$third-party\n||*.cdn.example.org^\n@@||safe.example.org^\n||-broken.example.org^\n0.0.0.0 bad_host!\n")
+
+	policy, err := NewDomainPolicy(files)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		url             string
+		wantErr         error
+		wantRedirectErr error
+	}{
+		{name: "exact allow", url: "https://example.org.evil.com/", wantErr: ErrDomainNotAllowed},
+		{name: "wildcard allow", url: "https://www.example.org/path"},
+		{name: "wildcard does not match apex", url: "https://example.org/", wantErr: ErrDomainNotAllowed},
+		{name: "idn allow", url: "https://xn--bcher-kva.example/"},
+		{name: "deny wins over allow", url: "https://ads.example.org/", wantErr: ErrDomainBlocked, wantRedirectErr: ErrDomainBlocked},
+		{name: "hosts feed entry", url: "https://example.com/", wantErr: ErrDomainBlocked, wantRedirectErr: ErrDomainBlocked},
+		{name: "hosts feed subdomain", url: "https://a.malware.example.org/", wantErr: ErrDomainBlocked, wantRedirectErr: ErrDomainBlocked},
+		{name: "adblock feed entry", url: "https://phish.example.org/", wantErr: ErrDomainBlocked, wantRedirectErr: ErrDomainBlocked},
+		{name: "adblock feed entry with modifiers", url: "https://tracker.example.org/", wantErr: ErrDomainBlocked, wantRedirectErr: ErrDomainBlocked},
+		{name: "adblock feed wildcard", url: "https://img.cdn.example.org/", wantErr: ErrDomainBlocked, wantRedirectErr: ErrDomainBlocked},
+		{name: "adblock feed wildcard does not match apex", url: "https://cdn.example.org/"},
+		{name: "adblock exception is ignored", url: "https://safe.example.org/"},
+		{name: "allowlist does not apply to redirects", url: "https://other.com/", wantErr: ErrDomainNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := policy.Check(test.url); !errors.Is(err, test.wantErr) {
+				t.Errorf("check: expected %v, got %v", test.wantErr, err)
+			}
+
+			if err := policy.CheckRedirect(test.url); !errors.Is(err, test.wantRedirectErr) {
+				t.Errorf("check redirect: expected %v, got %v", test.wantRedirectErr, err)
+			}
+		})
+	}
+}
+
+func TestDomainPolicyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist")
+	writePolicyFile(t, path, "evil.com\n")
+	policy, err := NewDomainPolicy(PolicyFiles{DenylistPath: path})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = policy.Check("https://good.com/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded, err := policy.reload(); err != nil || reloaded {
+		t.Fatalf("expected no reload of an unchanged file, got %v %v", reloaded, err)
+	}
+
+	writePolicyFile(t, path, "evil.com\ngood.com\n")
+	modTime := time.Now().Add(time.Minute)
+
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded, err := policy.reload(); err != nil || !reloaded {
+		t.Fatalf("expected a reload, got %v %v", reloaded, err)
+	}
+
+	if err = policy.Check("https://good.com/"); !errors.Is(err, ErrDomainBlocked) {
+		t.Errorf("expected %v, got %v", ErrDomainBlocked, err)
+	}
+
+	// a broken file keeps the previous rules
+	writePolicyFile(t, path, "-bad.com\n")
+	modTime = modTime.Add(time.Minute)
+
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = policy.reload(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if err = policy.Check("https://good.com/"); !errors.Is(err, ErrDomainBlocked) {
+		t.Errorf("expected %v, got %v", ErrDomainBlocked, err)
+	}
+}
+
+func TestDomainPolicySkipsInvalidFeedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feed")
+	writePolicyFile(t, path, "-bad.com\n||evil.com/ads^\nevil.com\n")
+	policy, err := NewDomainPolicy(PolicyFiles{BlocklistPath: path})
+
+	if err != nil {
+		t.Fatalf("invalid feed entries must be skipped, got %v", err)
+	}
+
+	if err = policy.Check("https://evil.com/"); !errors.Is(err, ErrDomainBlocked) {
+		t.Errorf("expected %v, got %v", ErrDomainBlocked, err)
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"strings"
+)
+
+// Bootstring parameters for punycode, RFC 3492 section 5.
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+var errPunycodeOverflow = errors.New("punycode overflow")
+
+// punycodeEncode implements the encoding procedure of RFC 3492 section 6.3. It
+// does not apply nameprep; callers lowercase labels before encoding.
+func punycodeEncode(input string) (string, error) {
+	runes := []rune(input)
+	var output strings.Builder
+
+	for _, r := range runes {
+		if r < 0x80 {
+			output.WriteRune(r)
+		}
+	}
+
+	basicCount := output.Len()
+	handled := basicCount
+
+	if basicCount > 0 {
+		output.WriteByte('-')
+	}
+
+	n := rune(punycodeInitialN)
+	delta := 0
+	bias := punycodeInitialBias
+
+	for handled < len(runes) {
+		m := rune(0x7fffffff)
+
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		if int(m-n) > (1<<31-1-delta)/(handled+1) {
+			return "", errPunycodeOverflow
+		}
+
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+
+			if r != n {
+				continue
+			}
+
+			q := delta
+
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+
+				if q < t {
+					break
+				}
+
+				output.WriteByte(punycodeDigit(t + (q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+
+			output.WriteByte(punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basicCount)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return output.String(), nil
+}
+
+func punycodeDigit(digit int) byte {
+	if digit < 26 {
+		return byte('a' + digit)
+	}
+
+	return byte('0' + digit - 26)
+}
+
+func punycodeAdapt(delta int, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+
+	delta += delta / numPoints
+	k := 0
+
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Query merge modes decide what happens to the query parameters of a redirect
+// request. By default they are dropped; otherwise they are added to the target
+// and a parameter present in both is resolved by the mode. Fragments need no
+// handling: browsers keep the fragment of the short url when the target has
+// none of its own.
+const (
+	QueryMergePreferTarget  = "prefer_target"
+	QueryMergePreferRequest = "prefer_request"
+	QueryMergeAppend        = "append"
+)
+
+var ErrInvalidQueryMerge = errors.New("invalid query merge")
+
+func validateQueryMerge(mode string) error {
+	switch mode {
+	case "", QueryMergePreferTarget, QueryMergePreferRequest, QueryMergeAppend:
+		return nil
+	default:
+		return fmt.Errorf("%w: query_merge must be one of prefer_target, prefer_request or append", ErrInvalidQueryMerge)
+	}
+}
+
+// MergeQuery adds query to target according to mode. The query of target is
+// kept as stored, only parameters from query are appended to it.
+func MergeQuery(target string, query url.Values, mode string) string {
+	if mode == "" || len(query) == 0 {
+		return target
+	}
+
+	parsed, err := url.Parse(target)
+
+	if err != nil {
+		return target
+	}
+
+	var pairs []string
+
+	if parsed.RawQuery != "" {
+		pairs = strings.Split(parsed.RawQuery, "&")
+	}
+
+	existing := make(map[string]bool, len(pairs))
+
+	for _, pair := range pairs {
+		existing[queryKey(pair)] = true
+	}
+
+	incoming := url.Values{}
+
+	for key, values := range query {
+		switch {
+		case !existing[key], mode == QueryMergeAppend:
+			incoming[key] = values
+		case mode == QueryMergePreferRequest:
+			incoming[key] = values
+			pairs = removeQueryKey(pairs, key)
+		}
+	}
+
+	if encoded := incoming.Encode(); encoded != "" {
+		pairs = append(pairs, encoded)
+	}
+
+	parsed.RawQuery = strings.Join(pairs, "&")
+	return parsed.String()
+}
+
+func queryKey(pair string) string {
+	key := pair
+
+	if index := strings.IndexByte(pair, '='); index >= 0 {
+		key = pair[:index]
+	}
+
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+
+	return key
+}
+
+func removeQueryKey(pairs []string, key string) []string {
+	result := pairs[:0]
+
+	for _, pair := range pairs {
+		if queryKey(pair) != key {
+			result = append(result, pair)
+		}
+	}
+
+	return result
+}
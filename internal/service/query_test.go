@@ -0,0 +1,39 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMergeQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		query  string
+		mode   string
+		want   string
+	}{
+		{name: "merging disabled", target: "https://example.com/?a=1", query: "b=2", want: "https://example.com/?a=1"},
+		{name: "empty request query", target: "https://example.com/?a=1", mode: QueryMergeAppend, want: "https://example.com/?a=1"},
+		{name: "target without query", target: "https://example.com/path", query: "b=2", mode: QueryMergePreferTarget, want: "https://example.com/path?b=2"},
+		{name: "prefer target", target: "https://example.com/?a=1&c=3", query: "a=x&b=2", mode: QueryMergePreferTarget, want: "https://example.com/?a=1&c=3&b=2"},
+		{name: "prefer request", target: "https://example.com/?a=1&c=3", query: "a=x&b=2", mode: QueryMergePreferRequest, want: "https://example.com/?c=3&a=x&b=2"},
+		{name: "append", target: "https://example.com/?a=1", query: "a=x", mode: QueryMergeAppend, want: "https://example.com/?a=1&a=x"},
+		{name: "target fragment is kept", target: "https://example.com/page#top", query: "b=2", mode: QueryMergeAppend, want: "https://example.com/page?b=2#top"},
+		{name: "escaped keys", target: "https://example.com/?a%20b=1", query: "a+b=2&c=%26", mode: QueryMergePreferRequest, want: "https://example.com/?a+b=2&c=%26"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := MergeQuery(test.target, query, test.mode); got != test.want {
+				t.Errorf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+// Redirect modes a link can choose; the numeric modes are the HTTP status codes
+// of the redirect and interstitial answers with an HTML page that redirects
+// after a delay.
+const (
+	RedirectMovedPermanently = "301"
+	RedirectFound            = "302"
+	RedirectTemporary        = "307"
+	RedirectPermanent        = "308"
+	RedirectInterstitial     = "interstitial"
+)
+
+const MaxInterstitialDelay = 60
+
+var ErrInvalidRedirect = errors.New("invalid redirect")
+
+func IsRedirectMode(mode string) bool {
+	switch mode {
+	case RedirectMovedPermanently, RedirectFound, RedirectTemporary, RedirectPermanent, RedirectInterstitial:
+		return true
+	default:
+		return false
+	}
+}
+
+// linkOptions validates the per link options of input; zero values leave the
+// choice to the server defaults. Rule targets are prepared like the original
+// url of the link; on a template link a target is a template only if it has
+// placeholders, so rules may still send visitors to a fixed url.
+func (service *URLService) linkOptions(input URLInput) (storage.LinkOptions, error) {
+	if err := validateQueryMerge(input.QueryMerge); err != nil {
+		return storage.LinkOptions{}, err
+	}
+
+	if input.RedirectMode != "" && !IsRedirectMode(input.RedirectMode) {
+		return storage.LinkOptions{}, fmt.Errorf("%w: redirect_mode must be one of 301, 302, 307, 308 or interstitial", ErrInvalidRedirect)
+	}
+
+	if input.InterstitialDelay != 0 {
+		if input.RedirectMode != RedirectInterstitial {
+			return storage.LinkOptions{}, fmt.Errorf("%w: interstitial_delay is only allowed with the interstitial redirect mode", ErrInvalidRedirect)
+		}
+
+		if input.InterstitialDelay < 0 || input.InterstitialDelay > MaxInterstitialDelay {
+			return storage.LinkOptions{}, fmt.Errorf("%w: interstitial_delay must be between 0 and %d seconds", ErrInvalidRedirect, MaxInterstitialDelay)
+		}
+	}
+
+	rules, err := service.rules.validate(input.Rules)
+
+	if err != nil {
+		return storage.LinkOptions{}, err
+	}
+
+	for index := range rules {
+		rules[index].Target, err = service.prepareOriginalURL(URLInput{
+			OriginalURL: rules[index].Target,
+			Template:    input.Template && strings.ContainsAny(rules[index].Target, "{}"),
+		})
+
+		if err != nil {
+			return storage.LinkOptions{}, fmt.Errorf("rule %d target: %w", index, err)
+		}
+	}
+
+	if len(rules) == 0 {
+		rules = nil
+	}
+
+	return storage.LinkOptions{
+		RedirectMode:      input.RedirectMode,
+		InterstitialDelay: input.InterstitialDelay,
+		QueryMerge:        input.QueryMerge,
+		Template:          input.Template,
+		Rules:             rules,
+	}, nil
+}
@@ -0,0 +1,393 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+const maxRules = 20
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Devices a rule can match, detected from the User-Agent header.
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceWindows = "windows"
+	DeviceMacOS   = "macos"
+	DeviceLinux   = "linux"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+var knownDevices = map[string]bool{
+	DeviceIOS:     true,
+	DeviceAndroid: true,
+	DeviceWindows: true,
+	DeviceMacOS:   true,
+	DeviceLinux:   true,
+	DeviceMobile:  true,
+	DeviceDesktop: true,
+	DeviceBot:     true,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Visitor is what rules know about a redirect request.
+type Visitor struct {
+	UserAgent      string
+	AcceptLanguage string
+	IP             net.IP
+	Time           time.Time
+}
+
+// RulesEngine picks the target of a link for a visitor. Without a GeoIP
+// database rules with countries cannot be created.
+type RulesEngine struct {
+	geoIP     *GeoIPDatabase
+	locations sync.Map
+}
+
+func NewRulesEngine(geoIP *GeoIPDatabase) *RulesEngine {
+	return &RulesEngine{
+		geoIP: geoIP,
+	}
+}
+
+// Select returns the target of the first rule that matches visitor.
+func (e *RulesEngine) Select(rules []storage.Rule, visitor Visitor) (string, bool) {
+	for _, rule := range rules {
+		if e.match(rule, visitor) {
+			return rule.Target, true
+		}
+	}
+
+	return "", false
+}
+
+func (e *RulesEngine) match(rule storage.Rule, visitor Visitor) bool {
+	if len(rule.Devices) > 0 && !matchDevice(rule.Devices, visitor.UserAgent) {
+		return false
+	}
+
+	if len(rule.Languages) > 0 && !matchLanguage(rule.Languages, visitor.AcceptLanguage) {
+		return false
+	}
+
+	if len(rule.Countries) > 0 && !contains(rule.Countries, e.geoIP.Country(visitor.IP)) {
+		return false
+	}
+
+	if rule.TimeWindow != nil && !e.matchTimeWindow(*rule.TimeWindow, visitor.Time) {
+		return false
+	}
+
+	return true
+}
+
+// validate checks everything but the targets and returns the rules with
+// conditions in canonical case.
+func (e *RulesEngine) validate(rules []storage.Rule) ([]storage.Rule, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("%w: at most %d rules are allowed", ErrInvalidRule, maxRules)
+	}
+
+	result := make([]storage.Rule, len(rules))
+
+	for index, rule := range rules {
+		if len(rule.Devices) == 0 && len(rule.Languages) == 0 && len(rule.Countries) == 0 && rule.TimeWindow == nil {
+			return nil, fmt.Errorf("%w: rule %d has no conditions", ErrInvalidRule, index)
+		}
+
+		rule.Devices = mapStrings(rule.Devices, strings.ToLower)
+		rule.Languages = mapStrings(rule.Languages, strings.ToLower)
+		rule.Countries = mapStrings(rule.Countries, strings.ToUpper)
+
+		for _, device := range rule.Devices {
+			if !knownDevices[device] {
+				return nil, fmt.Errorf("%w: rule %d: unknown device %q", ErrInvalidRule, index, device)
+			}
+		}
+
+		for _, language := range rule.Languages {
+			if !isLanguageTag(language) {
+				return nil, fmt.Errorf("%w: rule %d: invalid language %q", ErrInvalidRule, index, language)
+			}
+		}
+
+		if len(rule.Countries) > 0 && e.geoIP == nil {
+			return nil, fmt.Errorf("%w: rule %d: countries need a GeoIP database", ErrInvalidRule, index)
+		}
+
+		for _, country := range rule.Countries {
+			if len(country) != 2 || !isUpperLetters(country) {
+				return nil, fmt.Errorf("%w: rule %d: invalid country %q", ErrInvalidRule, index, country)
+			}
+		}
+
+		if rule.TimeWindow != nil {
+			window, err := e.validateTimeWindow(*rule.TimeWindow)
+
+			if err != nil {
+				return nil, fmt.Errorf("%w: rule %d: %v", ErrInvalidRule, index, err)
+			}
+
+			rule.TimeWindow = &window
+		}
+
+		result[index] = rule
+	}
+
+	return result, nil
+}
+
+func (e *RulesEngine) validateTimeWindow(window storage.TimeWindow) (storage.TimeWindow, error) {
+	if _, err := minuteOfDay(window.Start); err != nil {
+		return window, err
+	}
+
+	if _, err := minuteOfDay(window.End); err != nil {
+		return window, err
+	}
+
+	if window.Location == "" {
+		window.Location = "UTC"
+	}
+
+	if _, err := e.location(window.Location); err != nil {
+		return window, err
+	}
+
+	window.Weekdays = mapStrings(window.Weekdays, strings.ToLower)
+
+	for _, weekday := range window.Weekdays {
+		if _, ok := weekdays[weekday]; !ok {
+			return window, fmt.Errorf("unknown weekday %q", weekday)
+		}
+	}
+
+	return window, nil
+}
+
+// matchTimeWindow checks the weekday of the visit, so the part of a window
+// after midnight belongs to the next day.
+func (e *RulesEngine) matchTimeWindow(window storage.TimeWindow, now time.Time) bool {
+	location, err := e.location(window.Location)
+
+	if err != nil {
+		return false
+	}
+
+	now = now.In(location)
+	start, startErr := minuteOfDay(window.Start)
+	end, endErr := minuteOfDay(window.End)
+
+	if startErr != nil || endErr != nil {
+		return false
+	}
+
+	if len(window.Weekdays) > 0 {
+		matched := false
+
+		for _, weekday := range window.Weekdays {
+			if weekdays[weekday] == now.Weekday() {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+
+	if start <= end {
+		return minute >= start && minute < end
+	}
+
+	return minute >= start || minute < end
+}
+
+func (e *RulesEngine) location(name string) (*time.Location, error) {
+	if location, ok := e.locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+
+	location, err := time.LoadLocation(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.locations.Store(name, location)
+	return location, nil
+}
+
+func minuteOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// userAgentDevices classifies a User-Agent; the checks are ordered because
+// Android and iOS user agents also mention Linux and Mac OS.
+func userAgentDevices(userAgent string) map[string]bool {
+	userAgent = strings.ToLower(userAgent)
+	devices := make(map[string]bool)
+
+	switch {
+	case strings.Contains(userAgent, "bot") || strings.Contains(userAgent, "crawler") || strings.Contains(userAgent, "spider"):
+		devices[DeviceBot] = true
+		return devices
+	case strings.Contains(userAgent, "iphone") || strings.Contains(userAgent, "ipad") || strings.Contains(userAgent, "ipod"):
+		devices[DeviceIOS] = true
+	case strings.Contains(userAgent, "android"):
+		devices[DeviceAndroid] = true
+	case strings.Contains(userAgent, "windows"):
+		devices[DeviceWindows] = true
+	case strings.Contains(userAgent, "macintosh") || strings.Contains(userAgent, "mac os x"):
+		devices[DeviceMacOS] = true
+	case strings.Contains(userAgent, "linux"):
+		devices[DeviceLinux] = true
+	}
+
+	if devices[DeviceIOS] || devices[DeviceAndroid] || strings.Contains(userAgent, "mobile") {
+		devices[DeviceMobile] = true
+	} else if userAgent != "" {
+		devices[DeviceDesktop] = true
+	}
+
+	return devices
+}
+
+func matchDevice(devices []string, userAgent string) bool {
+	detected := userAgentDevices(userAgent)
+
+	for _, device := range devices {
+		if detected[device] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchLanguage compares the preferred language of the visitor: "en" matches
+// "en" and "en-us", "en-us" matches only "en-us".
+func matchLanguage(languages []string, acceptLanguage string) bool {
+	preferred := preferredLanguage(acceptLanguage)
+
+	if preferred == "" {
+		return false
+	}
+
+	for _, language := range languages {
+		if preferred == language || strings.HasPrefix(preferred, language+"-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// preferredLanguage returns the language with the highest quality in an
+// Accept-Language header; the first one wins a tie.
+func preferredLanguage(acceptLanguage string) string {
+	preferred := ""
+	best := 0.0
+
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		quality := 1.0
+
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+
+			if err != nil {
+				continue
+			}
+
+			quality = parsed
+		}
+
+		if tag == "" || tag == "*" || quality <= best {
+			continue
+		}
+
+		preferred = tag
+		best = quality
+	}
+
+	return preferred
+}
+
+func isLanguageTag(value string) bool {
+	if value == "" || strings.HasPrefix(value, "-") || strings.HasSuffix(value, "-") {
+		return false
+	}
+
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isUpperLetters(value string) bool {
+	for _, r := range value {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mapStrings(values []string, mapper func(string) string) []string {
+	if values == nil {
+		return nil
+	}
+
+	result := make([]string, len(values))
+
+	for index, value := range values {
+		result[index] = mapper(strings.TrimSpace(value))
+	}
+
+	return result
+}
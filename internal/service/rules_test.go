@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+const (
+	iPhoneUserAgent  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	androidUserAgent = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"
+	desktopUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+)
+
+func newTestGeoIP(t *testing.T) *GeoIPDatabase {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	content := "network,country\n# test ranges\n81.2.69.0/24,gb\n2001:db8::/32,DE\n\"1.0.0.0\",\"1.0.0.255\",\"AU\"\n"
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := LoadGeoIPDatabase(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return database
+}
+
+func TestGeoIPDatabase(t *testing.T) {
+	database := newTestGeoIP(t)
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "81.2.69.142", want: "GB"},
+		{ip: "81.2.70.1", want: ""},
+		{ip: "1.0.0.255", want: "AU"},
+		{ip: "2001:db8::1", want: "DE"},
+		{ip: "2001:db9::1", want: ""},
+		{ip: "0.0.0.1", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := database.Country(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.ip, test.want, got)
+		}
+	}
+}
+
+func TestRulesEngineSelect(t *testing.T) {
+	engine := NewRulesEngine(newTestGeoIP(t))
+	noon := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC) // a Monday
+	rules, err := engine.validate([]storage.Rule{
+		{Target: "ios", Devices: []string{"iOS"}},
+		{Target: "android", Devices: []string{"android"}},
+		{Target: "night", TimeWindow: &storage.TimeWindow{Start: "22:00", End: "02:00"}},
+		{Target: "weekend", TimeWindow: &storage.TimeWindow{Start: "00:00", End: "23:59", Weekdays: []string{"Sat", "sun"}}},
+		{Target: "tokyo lunch", TimeWindow: &storage.TimeWindow{Start: "12:00", End: "13:00", Location: "Asia/Tokyo"}},
+		{Target: "german in germany", Languages: []string{"de"}, Countries: []string{"de"}},
+		{Target: "brazilian", Languages: []string{"pt-BR"}},
+		{Target: "uk", Countries: []string{"gb"}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{name: "ios", visitor: Visitor{UserAgent: iPhoneUserAgent, Time: noon}, want: "ios"},
+		{name: "android", visitor: Visitor{UserAgent: androidUserAgent, Time: noon}, want: "android"},
+		{name: "first match wins", visitor: Visitor{UserAgent: iPhoneUserAgent, Time: noon.Add(11 * time.Hour)}, want: "ios"},
+		{name: "window across midnight", visitor: Visitor{UserAgent: desktopUserAgent, Time: noon.Add(13 * time.Hour)}, want: "night"},
+		{name: "window end is exclusive", visitor: Visitor{UserAgent: desktopUserAgent, Time: noon.Add(14 * time.Hour)}, want: ""},
+		{name: "weekday", visitor: Visitor{Time: noon.AddDate(0, 0, 5)}, want: "weekend"},
+		{name: "time zone", visitor: Visitor{Time: noon.Add(-8*time.Hour - 30*time.Minute)}, want: "tokyo lunch"},
+		{name: "all conditions must match", visitor: Visitor{AcceptLanguage: "de-DE", IP: net.ParseIP("81.2.69.1"), Time: noon}, want: "uk"},
+		{name: "language and country", visitor: Visitor{AcceptLanguage: "de-DE,en;q=0.5", IP: net.ParseIP("2001:db8::1"), Time: noon}, want: "german in germany"},
+		{name: "preferred language only", visitor: Visitor{AcceptLanguage: "en;q=0.4, pt-BR;q=0.9", Time: noon}, want: "brazilian"},
+		{name: "language region must match", visitor: Visitor{AcceptLanguage: "pt-PT", Time: noon}, want: ""},
+		{name: "no match", visitor: Visitor{UserAgent: desktopUserAgent, Time: noon}, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := engine.Select(rules, test.visitor)
+
+			if got != test.want || ok != (test.want != "") {
+				t.Errorf("expected %q, got %q %v", test.want, got, ok)
+			}
+		})
+	}
+}
+
+func TestRulesEngineValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  storage.Rule
+		geoIP bool
+	}{
+		{name: "no conditions", rule: storage.Rule{Target: "https://example.com/"}},
+		{name: "unknown device", rule: storage.Rule{Devices: []string{"toaster"}}},
+		{name: "invalid language", rule: storage.Rule{Languages: []string{"en_US"}}},
+		{name: "countries without database", rule: storage.Rule{Countries: []string{"DE"}}},
+		{name: "invalid country", rule: storage.Rule{Countries: []string{"DEU"}}, geoIP: true},
+		{name: "invalid time", rule: storage.Rule{TimeWindow: &storage.TimeWindow{Start: "25:00", End: "06:00"}}},
+		{name: "unknown location", rule: storage.Rule{TimeWindow: &storage.TimeWindow{Start: "22:00", End: "06:00", Location: "Mars/Olympus"}}},
+		{name: "unknown weekday", rule: storage.Rule{TimeWindow: &storage.TimeWindow{Start: "22:00", End: "06:00", Weekdays: []string{"someday"}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := NewRulesEngine(nil)
+
+			if test.geoIP {
+				engine = NewRulesEngine(newTestGeoIP(t))
+			}
+
+			if _, err := engine.validate([]storage.Rule{test.rule}); !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("expected %v, got %v", ErrInvalidRule, err)
+			}
+		})
+	}
+}
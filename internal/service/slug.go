@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+const (
+	SlugGeneratorCounter = "counter"
+	SlugGeneratorRandom  = "random"
+	SlugGeneratorHashids = "hashids"
+)
+
+var ErrSlugAttemptsExceeded = errors.New("could not generate unique short url")
+
+type SlugGenerator interface {
+	Generate(ctx context.Context) (string, error)
+}
+
+// Counter hands out increasing numbers. The counter and hashids generators take
+// it from storage so that keys are not reissued after a restart or by another
+// instance sharing the storage.
+type Counter interface {
+	NextCounter(ctx context.Context) (uint64, error)
+}
+
+func NewSlugGenerator(kind string, length int, salt string, counterOffset uint64, counter Counter) (SlugGenerator, error) {
+	switch kind {
+	case SlugGeneratorCounter:
+		return NewCounterSlugGenerator(counter, counterOffset), nil
+	case SlugGeneratorRandom:
+		return NewRandomSlugGenerator(length)
+	case SlugGeneratorHashids:
+		return NewHashidsSlugGenerator(counter, salt, length, counterOffset)
+	default:
+		return nil, fmt.Errorf("unknown slug generator %q", kind)
+	}
+}
+
+type counterSlugGenerator struct {
+	counter Counter
+	offset  uint64
+}
+
+func NewCounterSlugGenerator(counter Counter, offset uint64) SlugGenerator {
+	return &counterSlugGenerator{
+		counter: counter,
+		offset:  offset,
+	}
+}
+
+func (g *counterSlugGenerator) Generate(ctx context.Context) (string, error) {
+	n, err := g.counter.NextCounter(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	return encodeNumber(g.offset+n, base62Alphabet), nil
+}
+
+type randomSlugGenerator struct {
+	length int
+}
+
+func NewRandomSlugGenerator(length int) (SlugGenerator, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("invalid slug length %d", length)
+	}
+
+	return &randomSlugGenerator{
+		length: length,
+	}, nil
+}
+
+func (g *randomSlugGenerator) Generate(ctx context.Context) (string, error) {
+	result := make([]byte, g.length)
+	max := big.NewInt(int64(len(base62Alphabet)))
+
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+
+		if err != nil {
+			return "", err
+		}
+
+		result[i] = base62Alphabet[n.Int64()]
+	}
+
+	return string(result), nil
+}
+
+// MaxHashidsLength is the longest minimum length of hashids keys: the offset
+// that pads keys to the minimum length, 62^(length-2), overflows uint64 beyond it.
+const MaxHashidsLength = 12
+
+type hashidsSlugGenerator struct {
+	counter  Counter
+	alphabet string
+	salt     string
+	offset   uint64
+}
+
+func NewHashidsSlugGenerator(counter Counter, salt string, minLength int, counterOffset uint64) (SlugGenerator, error) {
+	if minLength <= 0 || minLength > MaxHashidsLength {
+		return nil, fmt.Errorf("invalid slug length %d: must be between 1 and %d", minLength, MaxHashidsLength)
+	}
+
+	offset := uint64(0)
+
+	if minLength > 2 {
+		offset = 1
+		for i := 0; i < minLength-2; i++ {
+			offset *= uint64(len(base62Alphabet))
+		}
+	}
+
+	return &hashidsSlugGenerator{
+		counter:  counter,
+		alphabet: shuffleAlphabet(base62Alphabet, salt),
+		salt:     salt,
+		offset:   offset + counterOffset,
+	}, nil
+}
+
+func (g *hashidsSlugGenerator) Generate(ctx context.Context) (string, error) {
+	n, err := g.counter.NextCounter(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	n += g.offset
+	lottery := g.alphabet[n%uint64(len(g.alphabet))]
+	alphabet := shuffleAlphabet(g.alphabet, string(lottery)+g.salt)
+	return string(lottery) + encodeNumber(n, alphabet), nil
+}
+
+func encodeNumber(n uint64, alphabet string) string {
+	base := uint64(len(alphabet))
+
+	if n == 0 {
+		return string(alphabet[0])
+	}
+
+	var result []byte
+
+	for n > 0 {
+		result = append(result, alphabet[n%base])
+		n /= base
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return string(result)
+}
+
+func shuffleAlphabet(alphabet string, salt string) string {
+	result := []byte(alphabet)
+	seed := sha256.Sum256([]byte(salt))
+	state := binary.BigEndian.Uint64(seed[:8])
+
+	for i := len(result) - 1; i > 0; i-- {
+		state ^= state << 13
+		state ^= state >> 7
+		state ^= state << 17
+		j := state % uint64(i+1)
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return string(result)
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+func TestSlugGenerators(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      string
+		minLength int
+	}{
+		{
+			name:      "counter",
+			kind:      SlugGeneratorCounter,
+			minLength: 1,
+		},
+		{
+			name:      "random",
+			kind:      SlugGeneratorRandom,
+			minLength: 8,
+		},
+		{
+			name:      "hashids",
+			kind:      SlugGeneratorHashids,
+			minLength: 6,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generator, err := NewSlugGenerator(test.kind, test.minLength, "salt", 0, storage.NewInMemoryStorage())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[string]bool)
+
+			for i := 0; i < 10000; i++ {
+				slug, err := generator.Generate(context.Background())
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(slug) < test.minLength {
+					t.Fatalf("slug %q is shorter than %d", slug, test.minLength)
+				}
+
+				if seen[slug] {
+					t.Fatalf("duplicate slug %q", slug)
+				}
+
+				seen[slug] = true
+			}
+		})
+	}
+}
+
+func TestCounterSlugsSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	seen := make(map[string]bool)
+
+	for run := 0; run < 2; run++ {
+		urlStorage, closer, err := storage.NewFileStorage(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		generator, err := NewSlugGenerator(SlugGeneratorCounter, 1, "", 0, urlStorage)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		urlService := NewURLService(urlStorage, "http://localhost:8080", generator, NewURLNormalizer([]string{"http", "https"}, 2048), nil, nil)
+
+		for i := 0; i < 3; i++ {
+			shortURL, err := urlService.SaveURL(ctx, URLInput{OriginalURL: fmt.Sprintf("https://example.com/%d/%d", run, i)}, "user")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if seen[shortURL] {
+				t.Fatalf("short url %s was issued twice", shortURL)
+			}
+
+			seen[shortURL] = true
+		}
+
+		closer.Close()
+	}
+}
+
+type fixedSlugGenerator struct {
+	slugs []string
+	index int
+}
+
+func (g *fixedSlugGenerator) Generate(ctx context.Context) (string, error) {
+	slug := g.slugs[g.index%len(g.slugs)]
+	g.index++
+	return slug, nil
+}
+
+func TestSaveURLRetriesOnCollision(t *testing.T) {
+	urlStorage := storage.NewInMemoryStorage()
+	generator := &fixedSlugGenerator{slugs: []string{"aaa", "aaa", "bbb"}}
+	urlService := NewURLService(urlStorage, "http://localhost:8080", generator, NewURLNormalizer([]string{"http", "https"}, 2048), nil, nil)
+
+	first, err := urlService.SaveURL(context.Background(), URLInput{OriginalURL: "https://example.com/1"}, "user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := urlService.SaveURL(context.Background(), URLInput{OriginalURL: "https://example.com/2"}, "user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != "http://localhost:8080/aaa" || second != "http://localhost:8080/bbb" {
+		t.Errorf("unexpected short urls: %s, %s", first, second)
+	}
+
+	generator = &fixedSlugGenerator{slugs: []string{"aaa"}}
+	urlService = NewURLService(urlStorage, "http://localhost:8080", generator, NewURLNormalizer([]string{"http", "https"}, 2048), nil, nil)
+	_, err = urlService.SaveURL(context.Background(), URLInput{OriginalURL: "https://example.com/3"}, "user")
+
+	if !errors.Is(err, ErrSlugAttemptsExceeded) {
+		t.Errorf("expected ErrSlugAttemptsExceeded, got %v", err)
+	}
+}
+
+func TestGeneratedKeysSkipReservedAliases(t *testing.T) {
+	ctx := context.Background()
+	generator := &fixedSlugGenerator{slugs: []string{"api", "aaa", "PING", "bbb"}}
+	urlService := NewURLService(storage.NewInMemoryStorage(), "http://localhost:8080", generator, NewURLNormalizer([]string{"http", "https"}, 2048), nil, nil)
+	shortURL, err := urlService.SaveURL(ctx, URLInput{OriginalURL: "https://example.com/1"}, "user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shortURL != "http://localhost:8080/aaa" {
+		t.Errorf("expected the reserved key to be skipped, got %s", shortURL)
+	}
+
+	result, err := urlService.SaveBatch(ctx, []URLInput{{CorrelationID: "1", OriginalURL: "https://example.com/2"}}, "user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result[0].ShortURL != "http://localhost:8080/bbb" {
+		t.Errorf("expected the reserved key to be skipped, got %s", result[0].ShortURL)
+	}
+}
+
+func TestHashidsSlugLengthLimit(t *testing.T) {
+	generator, err := NewHashidsSlugGenerator(storage.NewInMemoryStorage(), "salt", MaxHashidsLength, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slug, err := generator.Generate(context.Background())
+
+	if err != nil || len(slug) < MaxHashidsLength {
+		t.Errorf("expected a slug of at least %d characters, got %q %v", MaxHashidsLength, slug, err)
+	}
+
+	if _, err = NewHashidsSlugGenerator(storage.NewInMemoryStorage(), "salt", MaxHashidsLength+1, 0); err == nil {
+		t.Error("expected an error for a length whose offset overflows")
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+const (
+	BucketHour = storage.ClickBucketHour
+	BucketDay  = storage.ClickBucketDay
+)
+
+const topStatsLimit = 10
+
+var ErrInvalidBucket = errors.New("bucket must be hour or day")
+var ErrURLNotOwned = errors.New("url is not owned by user")
+
+type StatsService struct {
+	urlStorage   storage.Storage
+	clickStorage storage.ClickStorage
+	baseURL      string
+}
+
+func NewStatsService(urlStorage storage.Storage, clickStorage storage.ClickStorage, baseURL string) *StatsService {
+	return &StatsService{
+		urlStorage:   urlStorage,
+		clickStorage: clickStorage,
+		baseURL:      baseURL,
+	}
+}
+
+type HistogramItem struct {
+	Time   time.Time `json:"time"`
+	Clicks int       `json:"clicks"`
+}
+
+type CountItem struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type URLStats struct {
+	ShortURL       string          `json:"short_url"`
+	TotalClicks    int             `json:"total_clicks"`
+	UniqueVisitors int             `json:"unique_visitors"`
+	Bucket         string          `json:"bucket"`
+	Histogram      []HistogramItem `json:"histogram"`
+	TopReferrers   []CountItem     `json:"top_referrers"`
+	TopUserAgents  []CountItem     `json:"top_user_agents"`
+}
+
+func (service *StatsService) GetStats(ctx context.Context, userID string, shortURL string, bucket string) (*URLStats, error) {
+	if bucket == "" {
+		bucket = BucketDay
+	}
+
+	if bucket != BucketHour && bucket != BucketDay {
+		return nil, ErrInvalidBucket
+	}
+
+	owned, err := service.ownedURL(ctx, userID, shortURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := service.clickStorage.GetStats(ctx, shortURL, owned.CreatedAt, bucket, topStatsLimit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &URLStats{
+		ShortURL:       service.baseURL + "/" + shortURL,
+		TotalClicks:    stats.Total,
+		UniqueVisitors: stats.Visitors,
+		Bucket:         bucket,
+		Histogram:      make([]HistogramItem, len(stats.Histogram)),
+		TopReferrers:   countItems(stats.TopReferrers),
+		TopUserAgents:  countItems(stats.TopUserAgents),
+	}
+
+	for index, item := range stats.Histogram {
+		result.Histogram[index] = HistogramItem{
+			Time:   item.Time,
+			Clicks: item.Clicks,
+		}
+	}
+
+	return result, nil
+}
+
+// ownedURL finds the url of the user; its creation time hides clicks on an
+// earlier url that had the same short url.
+func (service *StatsService) ownedURL(ctx context.Context, userID string, shortURL string) (storage.UserData, error) {
+	userData, err := service.urlStorage.GetURLsByUserID(ctx, userID)
+
+	if err != nil {
+		return storage.UserData{}, err
+	}
+
+	for _, item := range userData {
+		if item.ShortURL == shortURL {
+			return item, nil
+		}
+	}
+
+	return storage.UserData{}, ErrURLNotOwned
+}
+
+func countItems(counts []storage.ClickCount) []CountItem {
+	result := make([]CountItem, len(counts))
+
+	for index, item := range counts {
+		result[index] = CountItem{
+			Value: item.Value,
+			Count: item.Count,
+		}
+	}
+
+	return result
+}
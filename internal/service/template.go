@@ -0,0 +1,290 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+var ErrInvalidTemplate = errors.New("invalid template")
+var ErrInvalidTemplateValue = errors.New("invalid template value")
+
+// placeholder parts of a template are escaped for the part of the url they are
+// in, so that a value can never change the host or break out of its component.
+const (
+	templatePath = iota
+	templateQuery
+	templateFragment
+)
+
+// templatePart is either a literal or, when name is set, a placeholder written
+// as {name} or {name=default}.
+type templatePart struct {
+	literal      string
+	name         string
+	defaultValue string
+	hasDefault   bool
+	component    int
+}
+
+// parseTemplate splits a template url into parts. Placeholders are allowed in
+// the path, query and fragment only; the scheme and host are always literal,
+// which keeps the domain policy meaningful for template links.
+func parseTemplate(template string) ([]templatePart, error) {
+	authorityEnd := templateAuthorityEnd(template)
+	var parts []templatePart
+	component := templatePath
+	literalStart := 0
+
+	for index := 0; index < len(template); index++ {
+		switch template[index] {
+		case '?':
+			if component == templatePath {
+				component = templateQuery
+			}
+		case '#':
+			component = templateFragment
+		case '}':
+			return nil, fmt.Errorf("%w: unexpected '}' at %d", ErrInvalidTemplate, index)
+		case '{':
+			if index < authorityEnd {
+				return nil, fmt.Errorf("%w: placeholders are not allowed in the scheme or host", ErrInvalidTemplate)
+			}
+
+			end := strings.IndexByte(template[index:], '}')
+
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unclosed '{' at %d", ErrInvalidTemplate, index)
+			}
+
+			part, err := parsePlaceholder(template[index+1 : index+end])
+
+			if err != nil {
+				return nil, err
+			}
+
+			if literalStart < index {
+				parts = append(parts, templatePart{literal: template[literalStart:index]})
+			}
+
+			part.component = component
+			parts = append(parts, part)
+			index += end
+			literalStart = index + 1
+		}
+	}
+
+	if literalStart < len(template) {
+		parts = append(parts, templatePart{literal: template[literalStart:]})
+	}
+
+	return parts, nil
+}
+
+func parsePlaceholder(body string) (templatePart, error) {
+	part := templatePart{name: body}
+
+	if index := strings.IndexByte(body, '='); index >= 0 {
+		part.name, part.defaultValue, part.hasDefault = body[:index], body[index+1:], true
+	}
+
+	if part.name == "" || strings.ContainsAny(part.defaultValue, "{") {
+		return part, fmt.Errorf("%w: invalid placeholder {%s}", ErrInvalidTemplate, body)
+	}
+
+	for _, r := range part.name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return part, fmt.Errorf("%w: invalid placeholder name %q", ErrInvalidTemplate, part.name)
+		}
+	}
+
+	return part, nil
+}
+
+// templateAuthorityEnd returns the index where the path, query or fragment of
+// the template starts.
+func templateAuthorityEnd(template string) int {
+	start := strings.Index(template, "://")
+
+	if start < 0 {
+		return len(template)
+	}
+
+	start += len("://")
+
+	if end := strings.IndexAny(template[start:], "/?#"); end >= 0 {
+		return start + end
+	}
+
+	return len(template)
+}
+
+// placeholderNames returns the names of the placeholders in the order of their
+// first appearance, which is the order path suffix segments fill them in.
+func placeholderNames(parts []templatePart) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, part := range parts {
+		if part.name != "" && !seen[part.name] {
+			seen[part.name] = true
+			names = append(names, part.name)
+		}
+	}
+
+	return names
+}
+
+// expandTemplate fills the placeholders from values; a placeholder without a
+// value uses its default or fails with ErrInvalidTemplateValue.
+func expandTemplate(parts []templatePart, values map[string]string) (string, error) {
+	var result strings.Builder
+
+	for _, part := range parts {
+		if part.name == "" {
+			result.WriteString(part.literal)
+			continue
+		}
+
+		value, ok := values[part.name]
+
+		if !ok {
+			if !part.hasDefault {
+				return "", fmt.Errorf("%w: no value for %q", ErrInvalidTemplateValue, part.name)
+			}
+
+			value = part.defaultValue
+		}
+
+		switch part.component {
+		case templatePath:
+			if value == "." || value == ".." {
+				return "", fmt.Errorf("%w: %q is not allowed in a path", ErrInvalidTemplateValue, value)
+			}
+			result.WriteString(url.PathEscape(value))
+		case templateQuery:
+			result.WriteString(url.QueryEscape(value))
+		default:
+			result.WriteString(url.PathEscape(value))
+		}
+	}
+
+	return result.String(), nil
+}
+
+// normalizeTemplate validates a template at creation time and returns it with
+// the scheme and host normalized. A sample expansion must be a valid url.
+func (n *URLNormalizer) normalizeTemplate(template string) (string, error) {
+	template = strings.TrimSpace(template)
+	parts, err := parseTemplate(template)
+
+	if err != nil {
+		return "", err
+	}
+
+	names := placeholderNames(parts)
+
+	if len(names) == 0 {
+		return "", fmt.Errorf("%w: template has no placeholders", ErrInvalidTemplate)
+	}
+
+	samples := make(map[string]string, len(names))
+
+	for _, name := range names {
+		samples[name] = "x"
+	}
+
+	sample, err := expandTemplate(parts, samples)
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = n.Normalize(sample); err != nil {
+		return "", err
+	}
+
+	authorityEnd := templateAuthorityEnd(template)
+	prefix, err := n.Normalize(template[:authorityEnd])
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(prefix, "/") + template[authorityEnd:], nil
+}
+
+// ResolveTarget returns where a redirect request for link goes. Template links
+// take placeholder values from the path segments after the key, in order, then
+// from query parameters of the same name. Other links accept no path suffix.
+// The query parameters not consumed by the template are merged according to
+// the query merge mode of the link.
+func ResolveTarget(link storage.Link, suffix string, query url.Values) (string, error) {
+	var segments []string
+
+	for _, segment := range strings.Split(suffix, "/") {
+		if segment == "" {
+			continue
+		}
+
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+
+		segments = append(segments, segment)
+	}
+
+	if !link.Options.Template {
+		if len(segments) > 0 {
+			return "", storage.ErrNotFound
+		}
+
+		return MergeQuery(link.FullURL, query, link.Options.QueryMerge), nil
+	}
+
+	parts, err := parseTemplate(link.FullURL)
+
+	if err != nil {
+		return "", err
+	}
+
+	names := placeholderNames(parts)
+
+	// a rule of a template link may send visitors to a fixed url, which takes
+	// none of the values
+	if len(names) == 0 {
+		return MergeQuery(link.FullURL, query, link.Options.QueryMerge), nil
+	}
+
+	if len(segments) > len(names) {
+		return "", storage.ErrNotFound
+	}
+
+	values := make(map[string]string, len(names))
+	remaining := url.Values{}
+
+	for key, value := range query {
+		remaining[key] = value
+	}
+
+	for index, name := range names {
+		if index < len(segments) {
+			values[name] = segments[index]
+		} else if _, ok := query[name]; ok {
+			values[name] = query.Get(name)
+		}
+
+		remaining.Del(name)
+	}
+
+	target, err := expandTemplate(parts, values)
+
+	if err != nil {
+		return "", err
+	}
+
+	return MergeQuery(target, remaining, link.Options.QueryMerge), nil
+}
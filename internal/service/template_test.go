@@ -0,0 +1,94 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+func TestNormalizeTemplate(t *testing.T) {
+	normalizer := NewURLNormalizer([]string{"http", "https"}, 128)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "path and query", input: "https://Shop.Example.com:443/{lang}/item?id={id}", want: "https://shop.example.com/{lang}/item?id={id}"},
+		{name: "query only", input: "https://example.com?q={q=go}", want: "https://example.com?q={q=go}"},
+		{name: "idn host", input: "https://bücher.example/{book}", want: "https://xn--bcher-kva.example/{book}"},
+		{name: "placeholder in host", input: "https://{sub}.example.com/", wantErr: ErrInvalidTemplate},
+		{name: "placeholder in scheme", input: "{scheme}://example.com/", wantErr: ErrInvalidTemplate},
+		{name: "unclosed placeholder", input: "https://example.com/{lang", wantErr: ErrInvalidTemplate},
+		{name: "stray brace", input: "https://example.com/lang}", wantErr: ErrInvalidTemplate},
+		{name: "invalid name", input: "https://example.com/{la-ng}", wantErr: ErrInvalidTemplate},
+		{name: "no placeholders", input: "https://example.com/", wantErr: ErrInvalidTemplate},
+		{name: "scheme not allowed", input: "ftp://example.com/{file}", wantErr: ErrSchemeNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := normalizer.normalizeTemplate(test.input)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	template := storage.Link{
+		FullURL: "https://shop.example.com/{lang=en}/item?id={id}#{section=top}",
+		Options: storage.LinkOptions{Template: true, QueryMerge: QueryMergeAppend},
+	}
+
+	tests := []struct {
+		name    string
+		link    storage.Link
+		suffix  string
+		query   string
+		want    string
+		wantErr error
+	}{
+		{name: "segments fill in order", link: template, suffix: "de/42", want: "https://shop.example.com/de/item?id=42#top"},
+		{name: "query fills the rest", link: template, suffix: "de", query: "id=42&section=reviews", want: "https://shop.example.com/de/item?id=42#reviews"},
+		{name: "segments win over query", link: template, suffix: "de/42", query: "id=7", want: "https://shop.example.com/de/item?id=42#top"},
+		{name: "unused parameters are merged", link: template, suffix: "de/42", query: "utm_source=x", want: "https://shop.example.com/de/item?id=42&utm_source=x#top"},
+		{name: "query only", link: template, query: "id=1&x=2", want: "https://shop.example.com/en/item?id=1&x=2#top"},
+		{name: "values cannot inject components", link: template, suffix: "a%3Fb", query: "id=1%232", want: "https://shop.example.com/a%3Fb/item?id=1%232#top"},
+		{name: "dot segments", link: template, suffix: "../42", wantErr: ErrInvalidTemplateValue},
+		{name: "missing value", link: template, suffix: "de", wantErr: ErrInvalidTemplateValue},
+		{name: "too many segments", link: template, suffix: "de/42/top/extra", wantErr: storage.ErrNotFound},
+		{name: "fixed rule target of a template link", link: storage.Link{FullURL: "https://example.com/fixed", Options: template.Options}, suffix: "de/42", query: "utm_source=x", want: "https://example.com/fixed?utm_source=x"},
+		{name: "plain link", link: storage.Link{FullURL: "https://example.com/"}, query: "a=1", want: "https://example.com/"},
+		{name: "plain link with suffix", link: storage.Link{FullURL: "https://example.com/"}, suffix: "a", wantErr: storage.ErrNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ResolveTarget(test.link, test.suffix, query)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -5,22 +5,40 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/iamsorryprincess/url-shortener/internal/storage"
 )
 
+const maxSlugAttempts = 10
+
+var ErrInvalidExpiration = errors.New("expires_at and ttl are mutually exclusive and must point to the future")
+
 type URLService struct {
-	storage   storage.Storage
-	userMutex sync.Mutex
-	baseURL   string
+	storage       storage.Storage
+	slugGenerator SlugGenerator
+	normalizer    *URLNormalizer
+	policy        *DomainPolicy
+	rules         *RulesEngine
+	userMutex     sync.Mutex
+	baseURL       string
 }
 
-func NewURLService(storage storage.Storage, baseURL string) *URLService {
+// NewURLService creates the service; a nil policy accepts every domain and a
+// nil rules engine evaluates rules without a GeoIP database.
+func NewURLService(storage storage.Storage, baseURL string, slugGenerator SlugGenerator, normalizer *URLNormalizer, policy *DomainPolicy, rules *RulesEngine) *URLService {
+	if rules == nil {
+		rules = NewRulesEngine(nil)
+	}
+
 	return &URLService{
-		storage:   storage,
-		userMutex: sync.Mutex{},
-		baseURL:   baseURL,
+		storage:       storage,
+		slugGenerator: slugGenerator,
+		normalizer:    normalizer,
+		policy:        policy,
+		rules:         rules,
+		userMutex:     sync.Mutex{},
+		baseURL:       baseURL,
 	}
 }
 
@@ -38,24 +56,86 @@ func (e *URLUniqueError) Unwrap() error {
 	return e.err
 }
 
-func (service *URLService) SaveURL(ctx context.Context, url string, userID string) (string, error) {
-	key := uuid.New().String()
-	err := service.storage.SaveURL(ctx, storage.URLInput{
-		FullURL:  url,
-		ShortURL: key,
-		UserID:   userID,
-	})
+func (service *URLService) SaveURL(ctx context.Context, input URLInput, userID string) (string, error) {
+	key := input.Alias
+	expiresAt, err := expirationTime(input, time.Now())
+
+	if err != nil {
+		return "", err
+	}
+
+	// the normalized form is what gets stored, so the uniqueness check in
+	// storage and GetByOriginalURL below see the same value
+	input.OriginalURL, err = service.prepareOriginalURL(input)
 
 	if err != nil {
+		return "", err
+	}
+
+	options, err := service.linkOptions(input)
+
+	if err != nil {
+		return "", err
+	}
+
+	if key != "" {
+		if err = validateAlias(key); err != nil {
+			return "", err
+		}
+
+		err = service.storage.SaveURL(ctx, storage.URLInput{
+			FullURL:   input.OriginalURL,
+			ShortURL:  key,
+			UserID:    userID,
+			ExpiresAt: expiresAt,
+			Options:   options,
+		})
+
+		if errors.Is(err, storage.ErrShortURLAlreadyExist) {
+			return "", service.aliasConflict(ctx, key, input.OriginalURL)
+		}
+	} else {
+		for attempt := 0; attempt < maxSlugAttempts; attempt++ {
+			key, err = service.slugGenerator.Generate(ctx)
+
+			if err != nil {
+				return "", err
+			}
+
+			// a generated key may spell a static route
+			if isReservedKey(key) {
+				err = storage.ErrShortURLAlreadyExist
+				continue
+			}
+
+			err = service.storage.SaveURL(ctx, storage.URLInput{
+				FullURL:   input.OriginalURL,
+				ShortURL:  key,
+				UserID:    userID,
+				ExpiresAt: expiresAt,
+				Options:   options,
+			})
+
+			if !errors.Is(err, storage.ErrShortURLAlreadyExist) {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, storage.ErrShortURLAlreadyExist) {
+			return "", ErrSlugAttemptsExceeded
+		}
+
 		if errors.Is(err, storage.ErrAlreadyExist) {
-			shortURL, getErr := service.storage.GetByOriginalURL(ctx, url)
+			shortURL, getErr := service.storage.GetByOriginalURL(ctx, input.OriginalURL)
 
 			if getErr != nil {
 				return "", getErr
 			}
 
 			return "", &URLUniqueError{
-				OriginalURL: url,
+				OriginalURL: input.OriginalURL,
 				ShortURL:    service.baseURL + "/" + shortURL,
 			}
 		}
@@ -66,8 +146,35 @@ func (service *URLService) SaveURL(ctx context.Context, url string, userID strin
 	return service.baseURL + "/" + key, nil
 }
 
+// GetURL returns the target of a short url. Targets whose domain was blocked
+// after the url had been created are reported with ErrDomainBlocked.
 func (service *URLService) GetURL(ctx context.Context, url string) (string, error) {
-	return service.storage.GetURL(ctx, url)
+	link, err := service.GetLink(ctx, url, nil)
+	return link.FullURL, err
+}
+
+// GetLink is GetURL that also returns how the link redirects. For a visitor the
+// target of the first matching rule of the link replaces the default target.
+func (service *URLService) GetLink(ctx context.Context, url string, visitor *Visitor) (storage.Link, error) {
+	link, err := service.storage.GetLink(ctx, url)
+
+	if err != nil {
+		return storage.Link{}, err
+	}
+
+	if visitor != nil {
+		if target, ok := service.rules.Select(link.Options.Rules, *visitor); ok {
+			link.FullURL = target
+		}
+	}
+
+	if service.policy != nil {
+		if err = service.policy.CheckRedirect(link.FullURL); err != nil {
+			return storage.Link{}, err
+		}
+	}
+
+	return link, nil
 }
 
 func (service *URLService) GetUserData(ctx context.Context, userID string) ([]storage.UserData, error) {
@@ -84,9 +191,53 @@ func (service *URLService) GetUserData(ctx context.Context, userID string) ([]st
 	return result, nil
 }
 
+// RestoreURLs undoes the deletion of the user's short urls that have not been
+// purged yet.
+func (service *URLService) RestoreURLs(ctx context.Context, userID string, shortURLs []string) (*RestoreResult, error) {
+	rejected, err := service.storage.RestoreBatch(ctx, userID, shortURLs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	isRejected := make(map[string]bool, len(rejected))
+
+	for _, shortURL := range rejected {
+		isRejected[shortURL] = true
+	}
+
+	result := &RestoreResult{
+		Restored: []string{},
+		Rejected: []string{},
+	}
+
+	for _, shortURL := range shortURLs {
+		if isRejected[shortURL] {
+			result.Rejected = append(result.Rejected, shortURL)
+		} else {
+			result.Restored = append(result.Restored, shortURL)
+		}
+	}
+
+	return result, nil
+}
+
+type RestoreResult struct {
+	Restored []string `json:"restored"`
+	Rejected []string `json:"rejected"`
+}
+
 type URLInput struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID     string         `json:"correlation_id"`
+	OriginalURL       string         `json:"original_url"`
+	Alias             string         `json:"alias,omitempty"`
+	ExpiresAt         *time.Time     `json:"expires_at,omitempty"`
+	TTL               int64          `json:"ttl,omitempty"`
+	RedirectMode      string         `json:"redirect_mode,omitempty"`
+	InterstitialDelay int            `json:"interstitial_delay,omitempty"`
+	QueryMerge        string         `json:"query_merge,omitempty"`
+	Template          bool           `json:"template,omitempty"`
+	Rules             []storage.Rule `json:"rules,omitempty"`
 }
 
 type URLResult struct {
@@ -94,27 +245,176 @@ type URLResult struct {
 	ShortURL      string `json:"short_url"`
 }
 
-func (service *URLService) SaveBatch(ctx context.Context, input []URLInput) ([]URLResult, error) {
+func (service *URLService) SaveBatch(ctx context.Context, input []URLInput, userID string) ([]URLResult, error) {
+	if err := validateBatchAliases(input); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expirations := make([]time.Time, len(input))
+	originals := make([]string, len(input))
+	options := make([]storage.LinkOptions, len(input))
+
+	for index, inputData := range input {
+		expiresAt, err := expirationTime(inputData, now)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expirations[index] = expiresAt
+		originals[index], err = service.prepareOriginalURL(inputData)
+
+		if err == nil {
+			options[index], err = service.linkOptions(inputData)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("correlation_id %q: %w", inputData.CorrelationID, err)
+		}
+	}
+
 	batchData := make([]storage.URLInput, len(input))
 	result := make([]URLResult, len(input))
 
-	for index, inputData := range input {
-		id := uuid.New().String()
-		batchData[index] = storage.URLInput{
-			ShortURL: id,
-			FullURL:  inputData.OriginalURL,
+	for attempt := 0; attempt < maxSlugAttempts; attempt++ {
+		reserved := false
+
+		for index, inputData := range input {
+			id := inputData.Alias
+
+			if id == "" {
+				var err error
+				id, err = service.slugGenerator.Generate(ctx)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if isReservedKey(id) {
+					reserved = true
+					break
+				}
+			}
+
+			batchData[index] = storage.URLInput{
+				ShortURL:  id,
+				FullURL:   originals[index],
+				UserID:    userID,
+				ExpiresAt: expirations[index],
+				Options:   options[index],
+			}
+			result[index] = URLResult{
+				CorrelationID: inputData.CorrelationID,
+				ShortURL:      service.baseURL + "/" + id,
+			}
+		}
+
+		// a generated key that spells a static route is a collision
+		if reserved {
+			continue
 		}
-		result[index] = URLResult{
-			CorrelationID: inputData.CorrelationID,
-			ShortURL:      service.baseURL + "/" + id,
+
+		err := service.storage.SaveBatch(ctx, batchData)
+
+		if err == nil {
+			return result, nil
+		}
+
+		if errors.Is(err, storage.ErrAlreadyExist) {
+			return nil, service.batchConflict(ctx, input, originals, err)
+		}
+
+		if !errors.Is(err, storage.ErrShortURLAlreadyExist) {
+			return nil, err
+		}
+
+		for _, inputData := range input {
+			if inputData.Alias == "" {
+				continue
+			}
+
+			_, getErr := service.storage.GetURL(ctx, inputData.Alias)
+
+			if !errors.Is(getErr, storage.ErrNotFound) {
+				return nil, ErrAliasTaken
+			}
+		}
+	}
+
+	return nil, ErrSlugAttemptsExceeded
+}
+
+// batchConflict names the item of the batch whose original url is already
+// stored; err is returned as is when the batch repeats an original url.
+func (service *URLService) batchConflict(ctx context.Context, input []URLInput, originals []string, err error) error {
+	for index, originalURL := range originals {
+		shortURL, getErr := service.storage.GetByOriginalURL(ctx, originalURL)
+
+		if getErr == nil {
+			return fmt.Errorf("correlation_id %q: %w", input[index].CorrelationID, &URLUniqueError{
+				OriginalURL: originalURL,
+				ShortURL:    service.baseURL + "/" + shortURL,
+				err:         err,
+			})
+		}
+	}
+
+	return err
+}
+
+func (service *URLService) aliasConflict(ctx context.Context, alias string, originalURL string) error {
+	fullURL, err := service.storage.GetURL(ctx, alias)
+
+	if err == nil && fullURL == originalURL {
+		return &URLUniqueError{
+			OriginalURL: originalURL,
+			ShortURL:    service.baseURL + "/" + alias,
 		}
 	}
 
-	err := service.storage.SaveBatch(ctx, batchData)
+	return ErrAliasTaken
+}
+
+// prepareOriginalURL normalizes the original url or template of input and
+// checks its domain against the policy.
+func (service *URLService) prepareOriginalURL(input URLInput) (string, error) {
+	var originalURL string
+	var err error
+
+	if input.Template {
+		originalURL, err = service.normalizer.normalizeTemplate(input.OriginalURL)
+	} else {
+		originalURL, err = service.normalizer.Normalize(input.OriginalURL)
+	}
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return result, nil
+	if service.policy != nil {
+		if err = service.policy.Check(originalURL); err != nil {
+			return "", err
+		}
+	}
+
+	return originalURL, nil
+}
+
+func expirationTime(input URLInput, now time.Time) (time.Time, error) {
+	switch {
+	case input.ExpiresAt != nil && input.TTL != 0:
+		return time.Time{}, ErrInvalidExpiration
+	case input.ExpiresAt != nil:
+		if !input.ExpiresAt.After(now) {
+			return time.Time{}, ErrInvalidExpiration
+		}
+		return input.ExpiresAt.UTC(), nil
+	case input.TTL < 0:
+		return time.Time{}, ErrInvalidExpiration
+	case input.TTL > 0:
+		return now.Add(time.Duration(input.TTL) * time.Second).UTC(), nil
+	default:
+		return time.Time{}, nil
+	}
 }
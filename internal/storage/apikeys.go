@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInvalidAPIKey is returned by authenticators for keys that are malformed,
+// unknown or revoked; it lives here so that the middleware can tell it from
+// other failures without depending on the service.
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKey is stored with the hash of the key only; the key itself is shown to
+// the user once when it is issued.
+type APIKey struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type APIKeyStorage interface {
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	// GetUserIDByAPIKeyHash returns ErrNotFound for unknown and revoked keys.
+	GetUserIDByAPIKeyHash(ctx context.Context, hash string) (string, error)
+	// RevokeAPIKey returns ErrNotFound if the user has no such key.
+	RevokeAPIKey(ctx context.Context, userID string, id string) error
+}
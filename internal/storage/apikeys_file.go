@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+const operationRevoke = "revoke"
+
+type fileAPIKeyStorage struct {
+	mutex sync.Mutex
+	index *inMemoryAPIKeyStorage
+	file  *os.File
+}
+
+type apiKeyData struct {
+	Operation string `json:"op,omitempty"`
+	APIKey
+}
+
+func NewFileAPIKeyStorage(filepath string) (APIKeyStorage, io.Closer, error) {
+	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index := newInMemoryAPIKeyStorage()
+	err = readLines(file, func(line []byte) error {
+		data := apiKeyData{}
+
+		if err := json.Unmarshal(line, &data); err != nil {
+			return err
+		}
+
+		if data.Operation == operationRevoke {
+			index.revoke(data.ID)
+		} else {
+			index.insert(data.APIKey)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &fileAPIKeyStorage{
+		mutex: sync.Mutex{},
+		index: index,
+		file:  file,
+	}, syncCloser{file: file}, nil
+}
+
+func (s *fileAPIKeyStorage) SaveAPIKey(ctx context.Context, key APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.index.checkInsert(key); err != nil {
+		return err
+	}
+
+	if err := s.write(apiKeyData{APIKey: key}); err != nil {
+		return err
+	}
+
+	s.index.insert(key)
+	return nil
+}
+
+func (s *fileAPIKeyStorage) GetUserIDByAPIKeyHash(ctx context.Context, hash string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.index.userID(hash)
+}
+
+func (s *fileAPIKeyStorage) RevokeAPIKey(ctx context.Context, userID string, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.index.owned(userID, id) {
+		return ErrNotFound
+	}
+
+	err := s.write(apiKeyData{
+		Operation: operationRevoke,
+		APIKey: APIKey{
+			ID:     id,
+			UserID: userID,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.index.revoke(id)
+	return nil
+}
+
+func (s *fileAPIKeyStorage) write(data apiKeyData) error {
+	bytes, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(bytes, '\n'))
+	return err
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+type inMemoryAPIKeyStorage struct {
+	mutex  sync.Mutex
+	keys   map[string]APIKey
+	hashes map[string]string
+}
+
+func NewInMemoryAPIKeyStorage() APIKeyStorage {
+	return newInMemoryAPIKeyStorage()
+}
+
+func newInMemoryAPIKeyStorage() *inMemoryAPIKeyStorage {
+	return &inMemoryAPIKeyStorage{
+		mutex:  sync.Mutex{},
+		keys:   make(map[string]APIKey),
+		hashes: make(map[string]string),
+	}
+}
+
+func (s *inMemoryAPIKeyStorage) SaveAPIKey(ctx context.Context, key APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.checkInsert(key); err != nil {
+		return err
+	}
+
+	s.insert(key)
+	return nil
+}
+
+func (s *inMemoryAPIKeyStorage) GetUserIDByAPIKeyHash(ctx context.Context, hash string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.userID(hash)
+}
+
+func (s *inMemoryAPIKeyStorage) RevokeAPIKey(ctx context.Context, userID string, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.owned(userID, id) {
+		return ErrNotFound
+	}
+
+	s.revoke(id)
+	return nil
+}
+
+// The helpers below expect the caller to hold the mutex; fileAPIKeyStorage
+// reuses them.
+
+func (s *inMemoryAPIKeyStorage) checkInsert(key APIKey) error {
+	if _, ok := s.keys[key.ID]; ok {
+		return ErrAlreadyExist
+	}
+
+	if _, ok := s.hashes[key.Hash]; ok {
+		return ErrAlreadyExist
+	}
+
+	return nil
+}
+
+func (s *inMemoryAPIKeyStorage) insert(key APIKey) {
+	s.keys[key.ID] = key
+	s.hashes[key.Hash] = key.ID
+}
+
+func (s *inMemoryAPIKeyStorage) userID(hash string) (string, error) {
+	id, ok := s.hashes[hash]
+
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return s.keys[id].UserID, nil
+}
+
+func (s *inMemoryAPIKeyStorage) owned(userID string, id string) bool {
+	key, ok := s.keys[id]
+	return ok && key.UserID == userID
+}
+
+func (s *inMemoryAPIKeyStorage) revoke(id string) {
+	key, ok := s.keys[id]
+
+	if !ok {
+		return
+	}
+
+	delete(s.keys, id)
+	delete(s.hashes, key.Hash)
+}
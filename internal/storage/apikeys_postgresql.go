@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx"
+)
+
+type postgresqlAPIKeyStorage struct {
+	db *sql.DB
+}
+
+func NewPostgresqlAPIKeyStorage(db *sql.DB) APIKeyStorage {
+	return &postgresqlAPIKeyStorage{
+		db: db,
+	}
+}
+
+func (s *postgresqlAPIKeyStorage) SaveAPIKey(ctx context.Context, key APIKey) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO public.api_keys (id, user_id, key_hash, created_at) VALUES ($1, $2, $3, $4)",
+		key.ID, key.UserID, key.Hash, key.CreatedAt)
+
+	var pgError pgx.PgError
+
+	if errors.As(err, &pgError) && pgError.Code == "23505" {
+		return ErrAlreadyExist
+	}
+
+	return err
+}
+
+func (s *postgresqlAPIKeyStorage) GetUserIDByAPIKeyHash(ctx context.Context, hash string) (string, error) {
+	userID := ""
+	err := s.db.QueryRowContext(ctx, "SELECT user_id FROM public.api_keys WHERE key_hash=$1 AND revoked_at IS NULL", hash).Scan(&userID)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+
+	return userID, nil
+}
+
+func (s *postgresqlAPIKeyStorage) RevokeAPIKey(ctx context.Context, userID string, id string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE public.api_keys SET revoked_at=now() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL", id, userID)
+
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache holds the results of GetLink lookups. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	// Get returns false if key is not cached or has expired.
+	Get(ctx context.Context, key string) (value string, ok bool, err error)
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Cached values are prefixed so that unknown short urls can be cached as well.
+const (
+	cachedLinkPrefix = "="
+	cachedNotFound   = "!"
+)
+
+// generationStripes keys share a generation; an invalidation of one of them
+// skips the pending fills of all of them.
+const generationStripes = 64
+
+type cachedStorage struct {
+	Storage
+	cache       Cache
+	ttl         time.Duration
+	negativeTTL time.Duration
+	// generations count invalidations, so that a value read from storage before
+	// an invalidation is not written to the cache after it
+	mutex       sync.RWMutex
+	generations [generationStripes]uint64
+}
+
+// NewCachedStorage serves GetURL and GetLink from cache and falls back to storage on a miss
+// or when the cache fails. Unknown short urls are cached for negativeTTL. Only
+// existing urls and unknown short urls are cached: deleted and expired urls are
+// always looked up in storage, so purging them needs no invalidation. Entries
+// never outlive the expiration time of their url.
+//
+// Writes that go through the returned storage invalidate the cache. Writes made
+// elsewhere become visible once the entry expires, so with a cache local to the
+// process, such as the LRU, a url deleted through another instance keeps
+// redirecting there for up to ttl. Instances sharing a storage must share the
+// cache as well; a fill racing with an invalidation made by another instance
+// may still cache the old value until it expires.
+func NewCachedStorage(storage Storage, cache Cache, ttl time.Duration, negativeTTL time.Duration) Storage {
+	return &cachedStorage{
+		Storage:     storage,
+		cache:       cache,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+func (s *cachedStorage) GetURL(ctx context.Context, shortURL string) (string, error) {
+	link, err := s.GetLink(ctx, shortURL)
+	return link.FullURL, err
+}
+
+func (s *cachedStorage) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	value, ok, err := s.cache.Get(ctx, shortURL)
+
+	if err != nil {
+		log.Println("cache:", err)
+	}
+
+	if ok {
+		if value == cachedNotFound {
+			return Link{}, ErrNotFound
+		}
+
+		var link Link
+
+		if strings.HasPrefix(value, cachedLinkPrefix) && json.Unmarshal([]byte(strings.TrimPrefix(value, cachedLinkPrefix)), &link) == nil {
+			// the cache may round the ttl up, so the expiration is checked on
+			// every hit as well
+			if link.ExpiresAt.IsZero() || link.ExpiresAt.After(time.Now()) {
+				return link, nil
+			}
+
+			s.invalidate(ctx, shortURL)
+		}
+	}
+
+	generation := s.generation(shortURL)
+	link, err := s.Storage.GetLink(ctx, shortURL)
+
+	switch {
+	case err == nil:
+		ttl := s.ttl
+
+		if !link.ExpiresAt.IsZero() {
+			if untilExpiry := time.Until(link.ExpiresAt); untilExpiry < ttl {
+				ttl = untilExpiry
+			}
+		}
+
+		if bytes, marshalErr := json.Marshal(link); marshalErr == nil {
+			s.fill(ctx, shortURL, generation, cachedLinkPrefix+string(bytes), ttl)
+		}
+	case errors.Is(err, ErrNotFound):
+		s.fill(ctx, shortURL, generation, cachedNotFound, s.negativeTTL)
+	}
+
+	return link, err
+}
+
+func (s *cachedStorage) SaveURL(ctx context.Context, input URLInput) error {
+	err := s.Storage.SaveURL(ctx, input)
+
+	// the short url may have been cached as unknown
+	if err == nil {
+		s.invalidate(ctx, input.ShortURL)
+	}
+
+	return err
+}
+
+func (s *cachedStorage) SaveBatch(ctx context.Context, batchInput []URLInput) error {
+	err := s.Storage.SaveBatch(ctx, batchInput)
+
+	if err == nil {
+		keys := make([]string, len(batchInput))
+
+		for index, input := range batchInput {
+			keys[index] = input.ShortURL
+		}
+
+		s.invalidate(ctx, keys...)
+	}
+
+	return err
+}
+
+func (s *cachedStorage) DeleteBatch(input []DeleteURLInput) ([]DeleteURLInput, error) {
+	rejected, err := s.Storage.DeleteBatch(input)
+
+	if err == nil {
+		keys := make([]string, len(input))
+
+		for index, item := range input {
+			keys[index] = item.URL
+		}
+
+		s.invalidate(context.Background(), keys...)
+	}
+
+	return rejected, err
+}
+
+func (s *cachedStorage) RestoreBatch(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	rejected, err := s.Storage.RestoreBatch(ctx, userID, shortURLs)
+
+	if err == nil {
+		s.invalidate(ctx, shortURLs...)
+	}
+
+	return rejected, err
+}
+
+func (s *cachedStorage) generation(key string) uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.generations[generationStripe(key)]
+}
+
+// fill caches the value unless the key has been invalidated since generation
+// was taken; invalidate waits for fills in progress, so a fill either sees the
+// new generation or is deleted by the invalidation.
+func (s *cachedStorage) fill(ctx context.Context, key string, generation uint64, value string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.generations[generationStripe(key)] != generation {
+		return
+	}
+
+	if err := s.cache.Set(ctx, key, value, ttl); err != nil {
+		log.Println("cache:", err)
+	}
+}
+
+func (s *cachedStorage) invalidate(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, key := range keys {
+		s.generations[generationStripe(key)]++
+	}
+
+	if err := s.cache.Delete(ctx, keys...); err != nil {
+		log.Println("cache:", err)
+	}
+}
+
+func generationStripe(key string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return hash.Sum32() % generationStripes
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type lruCache struct {
+	mutex    sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+type lruEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+// NewLRUCache keeps at most capacity entries in process memory and evicts the
+// least recently used entry when full.
+func NewLRUCache(capacity int) Cache {
+	return &lruCache{
+		mutex:    sync.Mutex{},
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (c *lruCache) Get(ctx context.Context, key string) (string, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.items[key]
+
+	if !ok {
+		return "", false, nil
+	}
+
+	entry := element.Value.(*lruEntry)
+
+	if !entry.expiresAt.After(c.now()) {
+		c.remove(element)
+		return "", false, nil
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *lruCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expiresAt := c.now().Add(ttl)
+
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+
+	return nil
+}
+
+func (c *lruCache) Delete(ctx context.Context, keys ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range keys {
+		if element, ok := c.items[key]; ok {
+			c.remove(element)
+		}
+	}
+
+	return nil
+}
+
+func (c *lruCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*lruEntry).key)
+}
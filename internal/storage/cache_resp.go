@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errRESPProtocol = errors.New("resp: protocol error")
+
+// RESPOptions configure a cache backed by a server that speaks the Redis
+// serialization protocol, such as Redis, Valkey or KeyDB.
+type RESPOptions struct {
+	Address   string
+	Password  string
+	KeyPrefix string
+	PoolSize  int
+	Timeout   time.Duration
+}
+
+// respError is an error reply of the server; the connection stays usable.
+type respError string
+
+func (e respError) Error() string {
+	return "resp: " + string(e)
+}
+
+type respCache struct {
+	options RESPOptions
+	mutex   sync.Mutex
+	idle    []*respConn
+	closed  bool
+}
+
+type respConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// NewRESPCache checks that the server is reachable and returns a cache that
+// keeps up to PoolSize idle connections. Close closes the idle connections.
+func NewRESPCache(options RESPOptions) (Cache, io.Closer, error) {
+	cache := &respCache{
+		options: options,
+		mutex:   sync.Mutex{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	if _, err := cache.do(ctx, "PING"); err != nil {
+		return nil, nil, err
+	}
+
+	return cache, cache, nil
+}
+
+func (c *respCache) Get(ctx context.Context, key string) (string, bool, error) {
+	reply, err := c.do(ctx, "GET", c.options.KeyPrefix+key)
+
+	if err != nil || reply == nil {
+		return "", false, err
+	}
+
+	value, ok := reply.(string)
+
+	if !ok {
+		return "", false, fmt.Errorf("%w: unexpected reply to GET: %v", errRESPProtocol, reply)
+	}
+
+	return value, true, nil
+}
+
+func (c *respCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	milliseconds := ttl.Milliseconds()
+
+	if milliseconds < 1 {
+		milliseconds = 1
+	}
+
+	_, err := c.do(ctx, "SET", c.options.KeyPrefix+key, value, "PX", strconv.FormatInt(milliseconds, 10))
+	return err
+}
+
+func (c *respCache) Delete(ctx context.Context, keys ...string) error {
+	args := make([]string, 0, len(keys)+1)
+	args = append(args, "DEL")
+
+	for _, key := range keys {
+		args = append(args, c.options.KeyPrefix+key)
+	}
+
+	_, err := c.do(ctx, args...)
+	return err
+}
+
+func (c *respCache) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+
+	for _, idle := range c.idle {
+		idle.conn.Close()
+	}
+
+	c.idle = nil
+	return nil
+}
+
+// do sends a command and returns its reply: nil, string, int64 or []interface{}.
+func (c *respCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := c.get(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(ctx, c.options.Timeout, args...)
+	var replyErr respError
+
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	c.put(conn)
+	return reply, err
+}
+
+func (c *respCache) get(ctx context.Context) (*respConn, error) {
+	c.mutex.Lock()
+
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, errors.New("resp: cache is closed")
+	}
+
+	if count := len(c.idle); count > 0 {
+		conn := c.idle[count-1]
+		c.idle = c.idle[:count-1]
+		c.mutex.Unlock()
+		return conn, nil
+	}
+
+	c.mutex.Unlock()
+	dialer := net.Dialer{Timeout: c.options.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.options.Address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &respConn{
+		conn:   netConn,
+		reader: bufio.NewReader(netConn),
+		writer: bufio.NewWriter(netConn),
+	}
+
+	if c.options.Password != "" {
+		if _, err = conn.do(ctx, c.options.Timeout, "AUTH", c.options.Password); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (c *respCache) put(conn *respConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed || len(c.idle) >= c.options.PoolSize {
+		conn.conn.Close()
+		return
+	}
+
+	c.idle = append(c.idle, conn)
+}
+
+func (c *respConn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return readRESPReply(c.reader)
+}
+
+func readRESPReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: malformed line %q", errRESPProtocol, line)
+	}
+
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, respError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+
+		if err != nil || count < 0 {
+			return nil, err
+		}
+
+		items := make([]interface{}, count)
+
+		for index := range items {
+			if items[index], err = readRESPReply(reader); err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown reply type %q", errRESPProtocol, kind)
+	}
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cache := NewLRUCache(2).(*lruCache)
+	cache.now = func() time.Time { return now }
+
+	cache.Set(ctx, "a", "1", time.Minute)
+	cache.Set(ctx, "b", "2", time.Minute)
+	cache.Get(ctx, "a")
+	cache.Set(ctx, "c", "3", time.Minute)
+
+	if _, ok, _ := cache.Get(ctx, "b"); ok {
+		t.Error("least recently used entry must be evicted")
+	}
+
+	if value, ok, _ := cache.Get(ctx, "a"); !ok || value != "1" {
+		t.Errorf("expected a=1, got %q %v", value, ok)
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, ok, _ := cache.Get(ctx, "c"); ok {
+		t.Error("expired entry must not be returned")
+	}
+
+	if len(cache.items) != 1 || cache.order.Len() != 1 {
+		t.Errorf("expired entry must be removed, %d items left", len(cache.items))
+	}
+}
+
+// countingStorage counts GetLink calls that reach the backend.
+type countingStorage struct {
+	Storage
+	mutex sync.Mutex
+	gets  int
+}
+
+func (s *countingStorage) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	s.mutex.Lock()
+	s.gets++
+	s.mutex.Unlock()
+	return s.Storage.GetLink(ctx, shortURL)
+}
+
+func TestCachedStorageInvalidation(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: NewInMemoryStorage()}
+	storage := NewCachedStorage(backend, NewLRUCache(16), time.Minute, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := storage.GetURL(ctx, "key"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+
+	if backend.gets != 1 {
+		t.Errorf("unknown key must be cached, backend was hit %d times", backend.gets)
+	}
+
+	if err := storage.SaveURL(ctx, URLInput{ShortURL: "key", FullURL: "https://example.com/", UserID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if fullURL, err := storage.GetURL(ctx, "key"); err != nil || fullURL != "https://example.com/" {
+			t.Fatalf("expected saved url, got %q %v", fullURL, err)
+		}
+	}
+
+	if backend.gets != 2 {
+		t.Errorf("saving must invalidate the negative entry and the url must be cached, backend was hit %d times", backend.gets)
+	}
+
+	if _, err := storage.DeleteBatch([]DeleteURLInput{{UserID: "user", URL: "key"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.GetURL(ctx, "key"); !errors.Is(err, ErrIsDeleted) {
+		t.Errorf("expected ErrIsDeleted after delete, got %v", err)
+	}
+}
+
+// pausingStorage holds GetLink after it has read from the backend until resume
+// is closed.
+type pausingStorage struct {
+	Storage
+	read   chan struct{}
+	resume chan struct{}
+}
+
+func (s *pausingStorage) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	link, err := s.Storage.GetLink(ctx, shortURL)
+	close(s.read)
+	<-s.resume
+	return link, err
+}
+
+func TestCachedStorageSkipsStaleFill(t *testing.T) {
+	ctx := context.Background()
+	backend := &pausingStorage{Storage: NewInMemoryStorage(), read: make(chan struct{}), resume: make(chan struct{})}
+	storage := NewCachedStorage(backend, NewLRUCache(16), time.Minute, time.Minute)
+	done := make(chan error)
+
+	go func() {
+		_, err := storage.GetURL(ctx, "key")
+		done <- err
+	}()
+
+	<-backend.read
+
+	// the url is saved after the lookup has read that it does not exist
+	if err := storage.SaveURL(ctx, URLInput{ShortURL: "key", FullURL: "https://example.com/", UserID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(backend.resume)
+
+	if err := <-done; !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from the racing lookup, got %v", err)
+	}
+
+	backend.read = make(chan struct{})
+
+	if fullURL, err := storage.GetURL(ctx, "key"); err != nil || fullURL != "https://example.com/" {
+		t.Errorf("the stale lookup must not be cached, got %q %v", fullURL, err)
+	}
+}
+
+func TestCachedStorageExpiration(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: NewInMemoryStorage()}
+	storage := NewCachedStorage(backend, NewLRUCache(16), time.Hour, time.Hour)
+	err := storage.SaveURL(ctx, URLInput{ShortURL: "key", FullURL: "https://example.com/", UserID: "user", ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.GetURL(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.GetURL(ctx, "key"); err != nil || backend.gets != 1 {
+		t.Fatalf("url must be cached until it expires, backend was hit %d times, %v", backend.gets, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err = storage.GetURL(ctx, "key"); !errors.Is(err, ErrIsExpired) {
+		t.Errorf("expected ErrIsExpired once the url expires, got %v", err)
+	}
+}
+
+// serveRESP is a minimal in-memory server for GET, SET, DEL and PING.
+func serveRESP(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Skip("loopback is not available:", err)
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	var mutex sync.Mutex
+	values := make(map[string]string)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+
+				for {
+					reply, err := readRESPReply(reader)
+
+					if err != nil {
+						return
+					}
+
+					args := make([]string, 0)
+
+					for _, arg := range reply.([]interface{}) {
+						args = append(args, arg.(string))
+					}
+
+					mutex.Lock()
+
+					switch args[0] {
+					case "PING":
+						conn.Write([]byte("+PONG\r\n"))
+					case "GET":
+						if value, ok := values[args[1]]; ok {
+							conn.Write([]byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"))
+						} else {
+							conn.Write([]byte("$-1\r\n"))
+						}
+					case "SET":
+						values[args[1]] = args[2]
+						conn.Write([]byte("+OK\r\n"))
+					case "DEL":
+						for _, key := range args[1:] {
+							delete(values, key)
+						}
+						conn.Write([]byte(":" + strconv.Itoa(len(args)-1) + "\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+
+					mutex.Unlock()
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestRESPCache(t *testing.T) {
+	ctx := context.Background()
+	cache, closer, err := NewRESPCache(RESPOptions{
+		Address:   serveRESP(t),
+		KeyPrefix: "test:",
+		PoolSize:  2,
+		Timeout:   time.Second,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer closer.Close()
+
+	if err = cache.Set(ctx, "key", cachedLinkPrefix+"https://example.com/", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok, err := cache.Get(ctx, "key"); err != nil || !ok || value != cachedLinkPrefix+"https://example.com/" {
+		t.Fatalf("expected cached value, got %q %v %v", value, ok, err)
+	}
+
+	if err = cache.Delete(ctx, "key", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := cache.Get(ctx, "key"); err != nil || ok {
+		t.Fatalf("expected a miss after delete, got %v %v", ok, err)
+	}
+
+	var replyErr respError
+
+	if _, err = cache.(*respCache).do(ctx, "FLUSHALL"); !errors.As(err, &replyErr) {
+		t.Errorf("expected an error reply, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Click buckets are named after the date_trunc fields they map to.
+const (
+	ClickBucketHour = "hour"
+	ClickBucketDay  = "day"
+)
+
+type Click struct {
+	ShortURL  string    `json:"shortUrl"`
+	Timestamp time.Time `json:"timestamp"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	ClientIP  string    `json:"clientIp,omitempty"`
+}
+
+type ClickBucket struct {
+	Time   time.Time
+	Clicks int
+}
+
+type ClickCount struct {
+	Value string
+	Count int
+}
+
+// ClickStats aggregates the clicks of a short url. Visitors are told apart by
+// client ip and user agent; the histogram is in UTC and ordered by time, the
+// top lists by count and then by value.
+type ClickStats struct {
+	Total         int
+	Visitors      int
+	Histogram     []ClickBucket
+	TopReferrers  []ClickCount
+	TopUserAgents []ClickCount
+}
+
+type ClickStorage interface {
+	SaveClicks(ctx context.Context, clicks []Click) error
+	// GetStats aggregates clicks made since the given time into buckets of one
+	// hour or one day and keeps the top most frequent referrers and user agents.
+	// Clicks before since belong to an earlier url with the same short url.
+	GetStats(ctx context.Context, shortURL string, since time.Time, bucket string, top int) (ClickStats, error)
+	// DeleteClicks deletes clicks made before clickedBefore.
+	DeleteClicks(ctx context.Context, clickedBefore time.Time) (int64, error)
+}
+
+// aggregateClicks is GetStats for backends that keep clicks in memory.
+func aggregateClicks(clicks []Click, since time.Time, bucket string, top int) ClickStats {
+	visitors := make(map[string]bool)
+	histogram := make(map[time.Time]int)
+	referrers := make(map[string]int)
+	userAgents := make(map[string]int)
+	total := 0
+
+	for _, click := range clicks {
+		if click.Timestamp.Before(since) {
+			continue
+		}
+
+		total++
+		visitors[click.ClientIP+"|"+click.UserAgent] = true
+		histogram[truncateToBucket(click.Timestamp, bucket)]++
+
+		if click.Referrer != "" {
+			referrers[click.Referrer]++
+		}
+
+		if click.UserAgent != "" {
+			userAgents[click.UserAgent]++
+		}
+	}
+
+	result := ClickStats{
+		Total:         total,
+		Visitors:      len(visitors),
+		Histogram:     make([]ClickBucket, 0, len(histogram)),
+		TopReferrers:  topCounts(referrers, top),
+		TopUserAgents: topCounts(userAgents, top),
+	}
+
+	for bucketTime, count := range histogram {
+		result.Histogram = append(result.Histogram, ClickBucket{
+			Time:   bucketTime,
+			Clicks: count,
+		})
+	}
+
+	sort.Slice(result.Histogram, func(i, j int) bool {
+		return result.Histogram[i].Time.Before(result.Histogram[j].Time)
+	})
+
+	return result
+}
+
+// deleteClicks removes clicks made before clickedBefore from clicks and returns
+// how many it removed.
+func deleteClicks(clicks map[string][]Click, clickedBefore time.Time) int64 {
+	var count int64
+
+	for shortURL, items := range clicks {
+		kept := items[:0]
+
+		for _, click := range items {
+			if click.Timestamp.Before(clickedBefore) {
+				count++
+				continue
+			}
+
+			kept = append(kept, click)
+		}
+
+		if len(kept) == 0 {
+			delete(clicks, shortURL)
+		} else {
+			clicks[shortURL] = kept
+		}
+	}
+
+	return count
+}
+
+func truncateToBucket(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+
+	if bucket == ClickBucketHour {
+		return t.Truncate(time.Hour)
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func topCounts(counts map[string]int, top int) []ClickCount {
+	result := make([]ClickCount, 0, len(counts))
+
+	for value, count := range counts {
+		result = append(result, ClickCount{
+			Value: value,
+			Count: count,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+
+	if len(result) > top {
+		result = result[:top]
+	}
+
+	return result
+}
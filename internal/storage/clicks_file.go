@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type fileClickStorage struct {
+	mutex    sync.Mutex
+	clicks   map[string][]Click
+	writer   *bufio.Writer
+	file     *os.File
+	filepath string
+}
+
+func NewFileClickStorage(filepath string) (ClickStorage, io.Closer, error) {
+	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clicks := make(map[string][]Click)
+	err = readLines(file, func(line []byte) error {
+		click := Click{}
+
+		if err := json.Unmarshal(line, &click); err != nil {
+			return err
+		}
+
+		clicks[click.ShortURL] = append(clicks[click.ShortURL], click)
+		return nil
+	})
+
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	storage := &fileClickStorage{
+		mutex:    sync.Mutex{},
+		clicks:   clicks,
+		writer:   bufio.NewWriter(file),
+		file:     file,
+		filepath: filepath,
+	}
+
+	return storage, storage, nil
+}
+
+func (s *fileClickStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	encoder := json.NewEncoder(s.writer)
+
+	for _, click := range clicks {
+		if err := encoder.Encode(click); err != nil {
+			return err
+		}
+	}
+
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+
+	for _, click := range clicks {
+		s.clicks[click.ShortURL] = append(s.clicks[click.ShortURL], click)
+	}
+
+	return nil
+}
+
+func (s *fileClickStorage) GetStats(ctx context.Context, shortURL string, since time.Time, bucket string, top int) (ClickStats, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return aggregateClicks(s.clicks[shortURL], since, bucket, top), nil
+}
+
+// DeleteClicks rewrites the file without the deleted clicks; the file is
+// replaced with a rename, so a crash leaves either the old or the new file.
+func (s *fileClickStorage) DeleteClicks(ctx context.Context, clickedBefore time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := deleteClicks(s.clicks, clickedBefore)
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	if err := s.rewrite(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Close flushes the clicks to disk and closes the file.
+func (s *fileClickStorage) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return syncCloser{file: s.file}.Close()
+}
+
+func (s *fileClickStorage) rewrite() error {
+	tmpPath := s.filepath + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+
+	for _, clicks := range s.clicks {
+		for _, click := range clicks {
+			if err = encoder.Encode(click); err != nil {
+				tmpFile.Close()
+				return err
+			}
+		}
+	}
+
+	if err = writer.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	tmpFile.Close()
+
+	if err = os.Rename(tmpPath, s.filepath); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.filepath, os.O_WRONLY|os.O_APPEND, 0777)
+
+	if err != nil {
+		return err
+	}
+
+	s.file.Close()
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	return nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type inMemoryClickStorage struct {
+	mutex  sync.Mutex
+	clicks map[string][]Click
+}
+
+func NewInMemoryClickStorage() ClickStorage {
+	return &inMemoryClickStorage{
+		mutex:  sync.Mutex{},
+		clicks: make(map[string][]Click),
+	}
+}
+
+func (s *inMemoryClickStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, click := range clicks {
+		s.clicks[click.ShortURL] = append(s.clicks[click.ShortURL], click)
+	}
+
+	return nil
+}
+
+func (s *inMemoryClickStorage) GetStats(ctx context.Context, shortURL string, since time.Time, bucket string, top int) (ClickStats, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return aggregateClicks(s.clicks[shortURL], since, bucket, top), nil
+}
+
+func (s *inMemoryClickStorage) DeleteClicks(ctx context.Context, clickedBefore time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return deleteClicks(s.clicks, clickedBefore), nil
+}
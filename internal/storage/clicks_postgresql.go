@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type postgresqlClickStorage struct {
+	db *sql.DB
+}
+
+func NewPostgresqlClickStorage(db *sql.DB) ClickStorage {
+	return &postgresqlClickStorage{
+		db: db,
+	}
+}
+
+func (s *postgresqlClickStorage) SaveClicks(ctx context.Context, clicks []Click) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO public.clicks (short_url, clicked_at, referrer, user_agent, client_ip) VALUES ($1, $2, $3, $4, $5)")
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err = stmt.ExecContext(ctx, click.ShortURL, click.Timestamp, click.Referrer, click.UserAgent, click.ClientIP)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresqlClickStorage) GetStats(ctx context.Context, shortURL string, since time.Time, bucket string, top int) (ClickStats, error) {
+	result := ClickStats{}
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*), COUNT(DISTINCT client_ip || '|' || user_agent) FROM public.clicks WHERE short_url=$1 AND clicked_at >= $2", shortURL, since).
+		Scan(&result.Total, &result.Visitors)
+
+	if err != nil {
+		return ClickStats{}, err
+	}
+
+	if result.Histogram, err = s.histogram(ctx, shortURL, since, bucket); err != nil {
+		return ClickStats{}, err
+	}
+
+	if result.TopReferrers, err = s.topCounts(ctx, shortURL, since, "referrer", top); err != nil {
+		return ClickStats{}, err
+	}
+
+	if result.TopUserAgents, err = s.topCounts(ctx, shortURL, since, "user_agent", top); err != nil {
+		return ClickStats{}, err
+	}
+
+	return result, nil
+}
+
+func (s *postgresqlClickStorage) DeleteClicks(ctx context.Context, clickedBefore time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM public.clicks WHERE clicked_at < $1", clickedBefore)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *postgresqlClickStorage) histogram(ctx context.Context, shortURL string, since time.Time, bucket string) ([]ClickBucket, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT date_trunc($3, clicked_at AT TIME ZONE 'UTC') AS bucket, COUNT(*) FROM public.clicks WHERE short_url=$1 AND clicked_at >= $2 GROUP BY bucket ORDER BY bucket", shortURL, since, bucket)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	result := make([]ClickBucket, 0)
+
+	for rows.Next() {
+		var item ClickBucket
+		if err = rows.Scan(&item.Time, &item.Clicks); err != nil {
+			return nil, err
+		}
+		item.Time = item.Time.UTC()
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+// topCounts orders ties by the byte order of the value, as aggregateClicks does.
+func (s *postgresqlClickStorage) topCounts(ctx context.Context, shortURL string, since time.Time, column string, top int) ([]ClickCount, error) {
+	query := fmt.Sprintf(`SELECT %[1]s, COUNT(*) AS clicks FROM public.clicks WHERE short_url=$1 AND clicked_at >= $2 AND %[1]s <> '' GROUP BY %[1]s ORDER BY clicks DESC, %[1]s COLLATE "C" LIMIT $3`, column)
+	rows, err := s.db.QueryContext(ctx, query, shortURL, since, top)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	result := make([]ClickCount, 0, top)
+
+	for rows.Next() {
+		var item ClickCount
+		if err = rows.Scan(&item.Value, &item.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DeletionPending   = "pending"
+	DeletionCompleted = "completed"
+	DeletionFailed    = "failed"
+)
+
+type DeletionStatus struct {
+	URL    string `json:"url"`
+	Status string `json:"status"`
+}
+
+// DeletionJournal persists accepted deletions until they are applied, so that
+// they can be replayed after a crash or restart, and keeps the outcome of
+// every url of a deletion job until it is purged.
+type DeletionJournal interface {
+	Append(ctx context.Context, input []DeleteURLInput) error
+	Complete(ctx context.Context, completed []DeleteURLInput, failed []DeleteURLInput) error
+	Pending(ctx context.Context) ([]DeleteURLInput, error)
+	GetJob(ctx context.Context, userID string, jobID string) ([]DeletionStatus, error)
+	PurgeFinished(ctx context.Context, finishedBefore time.Time) (int64, error)
+}
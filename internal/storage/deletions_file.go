@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const journalPurged = "purged"
+
+type fileDeletionJournal struct {
+	mutex sync.Mutex
+	index *inMemoryDeletionJournal
+	file  *os.File
+}
+
+type journalData struct {
+	JobID     string    `json:"jobId"`
+	ShortURL  string    `json:"shortUrl"`
+	UserID    string    `json:"userId"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// NewFileDeletionJournal replays the append-only journal at filepath, where every
+// line holds the latest state of one url of a job, and then compacts it.
+func NewFileDeletionJournal(filepath string) (DeletionJournal, io.Closer, error) {
+	index, err := readJournal(filepath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpPath := filepath + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	journal := &fileDeletionJournal{
+		mutex: sync.Mutex{},
+		index: index,
+		file:  tmpFile,
+	}
+
+	for key, entry := range index.entries {
+		if err = journal.write(key, entry.status, entry.updatedAt); err != nil {
+			tmpFile.Close()
+			return nil, nil, err
+		}
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return nil, nil, err
+	}
+
+	tmpFile.Close()
+
+	if err = os.Rename(tmpPath, filepath); err != nil {
+		return nil, nil, err
+	}
+
+	journal.file, err = os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND, 0777)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return journal, syncCloser{file: journal.file}, nil
+}
+
+func (j *fileDeletionJournal) Append(ctx context.Context, input []DeleteURLInput) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	now := time.Now()
+
+	for _, data := range input {
+		if err := j.write(data, DeletionPending, now); err != nil {
+			return err
+		}
+	}
+
+	// an accepted deletion must survive a crash, so the journal is synced before returning
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+
+	j.index.set(input, DeletionPending, now)
+	return nil
+}
+
+func (j *fileDeletionJournal) Complete(ctx context.Context, completed []DeleteURLInput, failed []DeleteURLInput) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	now := time.Now()
+
+	for _, data := range completed {
+		if err := j.write(data, DeletionCompleted, now); err != nil {
+			return err
+		}
+	}
+
+	for _, data := range failed {
+		if err := j.write(data, DeletionFailed, now); err != nil {
+			return err
+		}
+	}
+
+	j.index.set(completed, DeletionCompleted, now)
+	j.index.set(failed, DeletionFailed, now)
+	return nil
+}
+
+func (j *fileDeletionJournal) Pending(ctx context.Context) ([]DeleteURLInput, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.index.pending(), nil
+}
+
+func (j *fileDeletionJournal) GetJob(ctx context.Context, userID string, jobID string) ([]DeletionStatus, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.index.job(userID, jobID)
+}
+
+func (j *fileDeletionJournal) PurgeFinished(ctx context.Context, finishedBefore time.Time) (int64, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	var count int64
+
+	for _, key := range j.index.finished(finishedBefore) {
+		if err := j.write(key, journalPurged, time.Now()); err != nil {
+			return count, err
+		}
+
+		delete(j.index.entries, key)
+		count++
+	}
+
+	return count, nil
+}
+
+func (j *fileDeletionJournal) write(data DeleteURLInput, status string, updatedAt time.Time) error {
+	bytes, err := json.Marshal(&journalData{
+		JobID:     data.JobID,
+		ShortURL:  data.URL,
+		UserID:    data.UserID,
+		Status:    status,
+		UpdatedAt: updatedAt,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = j.file.Write(append(bytes, '\n'))
+	return err
+}
+
+func readJournal(filepath string) (*inMemoryDeletionJournal, error) {
+	index := newInMemoryDeletionJournal()
+	file, err := os.Open(filepath)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, err
+	}
+
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	for {
+		bytes, readErr := reader.ReadBytes('\n')
+
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			return nil, readErr
+		}
+
+		data := journalData{}
+
+		if err = json.Unmarshal(bytes, &data); err != nil {
+			return nil, err
+		}
+
+		key := DeleteURLInput{
+			JobID:  data.JobID,
+			UserID: data.UserID,
+			URL:    data.ShortURL,
+		}
+
+		if data.Status == journalPurged {
+			delete(index.entries, key)
+			continue
+		}
+
+		index.set([]DeleteURLInput{key}, data.Status, data.UpdatedAt)
+	}
+
+	return index, nil
+}
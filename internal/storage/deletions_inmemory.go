@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type deletionEntry struct {
+	status    string
+	updatedAt time.Time
+}
+
+type inMemoryDeletionJournal struct {
+	mutex   sync.Mutex
+	entries map[DeleteURLInput]deletionEntry
+}
+
+func NewInMemoryDeletionJournal() DeletionJournal {
+	return newInMemoryDeletionJournal()
+}
+
+func newInMemoryDeletionJournal() *inMemoryDeletionJournal {
+	return &inMemoryDeletionJournal{
+		mutex:   sync.Mutex{},
+		entries: make(map[DeleteURLInput]deletionEntry),
+	}
+}
+
+func (j *inMemoryDeletionJournal) Append(ctx context.Context, input []DeleteURLInput) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.set(input, DeletionPending, time.Now())
+	return nil
+}
+
+func (j *inMemoryDeletionJournal) Complete(ctx context.Context, completed []DeleteURLInput, failed []DeleteURLInput) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	now := time.Now()
+	j.set(completed, DeletionCompleted, now)
+	j.set(failed, DeletionFailed, now)
+	return nil
+}
+
+func (j *inMemoryDeletionJournal) Pending(ctx context.Context) ([]DeleteURLInput, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.pending(), nil
+}
+
+func (j *inMemoryDeletionJournal) GetJob(ctx context.Context, userID string, jobID string) ([]DeletionStatus, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.job(userID, jobID)
+}
+
+func (j *inMemoryDeletionJournal) PurgeFinished(ctx context.Context, finishedBefore time.Time) (int64, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	finished := j.finished(finishedBefore)
+
+	for _, key := range finished {
+		delete(j.entries, key)
+	}
+
+	return int64(len(finished)), nil
+}
+
+// The helpers below expect the caller to hold the mutex; fileDeletionJournal
+// reuses them the same way fileStorage reuses inMemoryStorage.
+
+func (j *inMemoryDeletionJournal) set(input []DeleteURLInput, status string, updatedAt time.Time) {
+	for _, data := range input {
+		j.entries[data] = deletionEntry{
+			status:    status,
+			updatedAt: updatedAt,
+		}
+	}
+}
+
+func (j *inMemoryDeletionJournal) pending() []DeleteURLInput {
+	var result []DeleteURLInput
+
+	for key, entry := range j.entries {
+		if entry.status == DeletionPending {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+func (j *inMemoryDeletionJournal) job(userID string, jobID string) ([]DeletionStatus, error) {
+	var result []DeletionStatus
+
+	for key, entry := range j.entries {
+		if key.JobID == jobID && key.UserID == userID {
+			result = append(result, DeletionStatus{
+				URL:    key.URL,
+				Status: entry.status,
+			})
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].URL < result[b].URL
+	})
+
+	return result, nil
+}
+
+func (j *inMemoryDeletionJournal) finished(finishedBefore time.Time) []DeleteURLInput {
+	var result []DeleteURLInput
+
+	for key, entry := range j.entries {
+		if entry.status != DeletionPending && entry.updatedAt.Before(finishedBefore) {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
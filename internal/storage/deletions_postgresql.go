@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type postgresqlDeletionJournal struct {
+	db *sql.DB
+}
+
+func NewPostgresqlDeletionJournal(db *sql.DB) DeletionJournal {
+	return &postgresqlDeletionJournal{
+		db: db,
+	}
+}
+
+func (j *postgresqlDeletionJournal) Append(ctx context.Context, input []DeleteURLInput) error {
+	tx, err := j.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err = execDeletionBatch(ctx, tx, "INSERT INTO public.deletion_jobs (job_id, user_id, short_url) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", input); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (j *postgresqlDeletionJournal) Complete(ctx context.Context, completed []DeleteURLInput, failed []DeleteURLInput) error {
+	tx, err := j.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+	query := "UPDATE public.deletion_jobs SET status=$4, updated_at=now() WHERE job_id=$1 AND user_id=$2 AND short_url=$3"
+
+	if err = execDeletionBatch(ctx, tx, query, completed, DeletionCompleted); err != nil {
+		return err
+	}
+
+	if err = execDeletionBatch(ctx, tx, query, failed, DeletionFailed); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (j *postgresqlDeletionJournal) Pending(ctx context.Context) ([]DeleteURLInput, error) {
+	rows, err := j.db.QueryContext(ctx, "SELECT job_id, user_id, short_url FROM public.deletion_jobs WHERE status=$1 ORDER BY created_at", DeletionPending)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var result []DeleteURLInput
+
+	for rows.Next() {
+		var data DeleteURLInput
+		err = rows.Scan(&data.JobID, &data.UserID, &data.URL)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (j *postgresqlDeletionJournal) GetJob(ctx context.Context, userID string, jobID string) ([]DeletionStatus, error) {
+	rows, err := j.db.QueryContext(ctx, "SELECT short_url, status FROM public.deletion_jobs WHERE job_id=$1 AND user_id=$2 ORDER BY short_url", jobID, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var result []DeletionStatus
+
+	for rows.Next() {
+		var status DeletionStatus
+		err = rows.Scan(&status.URL, &status.Status)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, status)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return result, nil
+}
+
+func (j *postgresqlDeletionJournal) PurgeFinished(ctx context.Context, finishedBefore time.Time) (int64, error) {
+	result, err := j.db.ExecContext(ctx, "DELETE FROM public.deletion_jobs WHERE status<>$1 AND updated_at<$2", DeletionPending, finishedBefore)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// execDeletionBatch runs query once per url with job_id, user_id and short_url
+// as the first three arguments followed by args.
+func execDeletionBatch(ctx context.Context, tx *sql.Tx, query string, input []DeleteURLInput, args ...interface{}) error {
+	if len(input) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, data := range input {
+		_, err = stmt.ExecContext(ctx, append([]interface{}{data.JobID, data.UserID, data.URL}, args...)...)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
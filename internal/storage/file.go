@@ -4,24 +4,44 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
-	"errors"
 	"io"
+	"log"
 	"os"
 	"sync"
+	"time"
 )
 
+const (
+	operationSave    = ""
+	operationBatch   = "batch"
+	operationDelete  = "delete"
+	operationRestore = "restore"
+	operationPurge   = "purge"
+	operationCounter = "counter"
+)
+
+// counterBlockSize counters are reserved with a single record; a restart skips
+// the unused rest of the block.
+const counterBlockSize = 100
+
 type fileStorage struct {
 	mutex    sync.Mutex
-	storage  map[string]string
-	userData map[string][]UserData
+	index    *inMemoryStorage
 	file     *os.File
-	encoder  *json.Encoder
+	reserved uint64
 }
 
 type storageData struct {
-	ShortURL string `json:"shortUrl"`
-	FullURL  string `json:"fullUrl"`
-	UserID   string `json:"userId"`
+	Operation string        `json:"op,omitempty"`
+	ShortURL  string        `json:"shortUrl,omitempty"`
+	FullURL   string        `json:"fullUrl,omitempty"`
+	UserID    string        `json:"userId,omitempty"`
+	ExpiresAt *time.Time    `json:"expiresAt,omitempty"`
+	CreatedAt *time.Time    `json:"createdAt,omitempty"`
+	DeletedAt *time.Time    `json:"deletedAt,omitempty"`
+	Options   *LinkOptions  `json:"options,omitempty"`
+	Items     []storageData `json:"items,omitempty"`
+	Counter   uint64        `json:"counter,omitempty"`
 }
 
 func NewFileStorage(filepath string) (Storage, io.Closer, error) {
@@ -31,85 +51,350 @@ func NewFileStorage(filepath string) (Storage, io.Closer, error) {
 		return nil, nil, openFileErr
 	}
 
-	storage := make(map[string]string)
-	reader := bufio.NewReader(file)
-	userData := make(map[string][]UserData)
-
-	for {
-		bytes, readErr := reader.ReadBytes('\n')
-
-		if readErr != nil {
-			if readErr == io.EOF {
-				break
-			}
-			file.Close()
-			return nil, nil, readErr
-		}
-
+	index := newInMemoryStorage()
+	err := readLines(file, func(line []byte) error {
 		data := &storageData{}
-		unmarshalErr := json.Unmarshal(bytes, data)
 
-		if unmarshalErr != nil {
-			file.Close()
-			return nil, nil, unmarshalErr
+		if err := json.Unmarshal(line, data); err != nil {
+			return err
 		}
 
-		storage[data.ShortURL] = data.FullURL
-		userData[data.UserID] = append(userData[data.UserID], UserData{
-			FullURL:  data.FullURL,
-			ShortURL: data.ShortURL,
-		})
+		replay(index, data)
+		return nil
+	})
+
+	if err != nil {
+		file.Close()
+		return nil, nil, err
 	}
 
 	return &fileStorage{
 		mutex:    sync.Mutex{},
-		storage:  storage,
-		userData: userData,
+		index:    index,
 		file:     file,
-		encoder:  json.NewEncoder(file),
-	}, file, nil
+		reserved: index.counter,
+	}, syncCloser{file: file}, nil
 }
 
 func (s *fileStorage) SaveURL(ctx context.Context, input URLInput) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.storage[input.ShortURL] = input.FullURL
-	s.userData[input.UserID] = append(s.userData[input.UserID], UserData{
-		ShortURL: input.ShortURL,
-		FullURL:  input.FullURL,
-	})
 
-	data := &storageData{
-		ShortURL: input.ShortURL,
-		FullURL:  input.FullURL,
-		UserID:   input.UserID,
+	if err := s.index.checkInsert([]URLInput{input}); err != nil {
+		return err
 	}
 
-	err := s.encoder.Encode(data)
+	now := time.Now()
 
-	if err != nil {
+	if err := s.write(newSaveData(input, now)); err != nil {
 		return err
 	}
 
+	s.index.insert(input, now)
 	return nil
 }
 
 func (s *fileStorage) GetURL(ctx context.Context, shortURL string) (string, error) {
-	return s.storage[shortURL], nil
+	link, err := s.GetLink(ctx, shortURL)
+	return link.FullURL, err
+}
+
+func (s *fileStorage) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.index.get(shortURL, time.Now())
 }
 
 func (s *fileStorage) GetURLsByUserID(ctx context.Context, userID string) ([]UserData, error) {
-	return s.userData[userID], nil
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]UserData(nil), s.index.userData[userID]...), nil
 }
 
+// SaveBatch writes the whole batch as a single line; readLines drops a torn
+// line, so a crash never leaves half of a batch in the file.
 func (s *fileStorage) SaveBatch(ctx context.Context, batchInput []URLInput) error {
-	return errors.New("method not implemented")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.index.checkInsert(batchInput); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	data := &storageData{
+		Operation: operationBatch,
+		Items:     make([]storageData, len(batchInput)),
+	}
+
+	for i, input := range batchInput {
+		data.Items[i] = *newSaveData(input, now)
+	}
+
+	if err := s.write(data); err != nil {
+		return err
+	}
+
+	for _, input := range batchInput {
+		s.index.insert(input, now)
+	}
+
+	return nil
 }
 
 func (s *fileStorage) GetByOriginalURL(ctx context.Context, originalURL string) (string, error) {
-	return "", errors.New("method not implemented")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.index.getByOriginal(originalURL, time.Now())
+}
+
+func (s *fileStorage) DeleteBatch(input []DeleteURLInput) ([]DeleteURLInput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var rejected []DeleteURLInput
+	now := time.Now()
+
+	for _, data := range input {
+		record, ok := s.index.urls[data.URL]
+
+		if !ok || record.userID != data.UserID {
+			rejected = append(rejected, data)
+			continue
+		}
+
+		if record.isDeleted {
+			continue
+		}
+
+		err := s.write(&storageData{
+			Operation: operationDelete,
+			ShortURL:  data.URL,
+			UserID:    data.UserID,
+			DeletedAt: &now,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.index.markDeleted(data, now)
+	}
+
+	return rejected, nil
+}
+
+func (s *fileStorage) RestoreBatch(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var rejected []string
+
+	for _, shortURL := range shortURLs {
+		record, ok := s.index.urls[shortURL]
+
+		if !ok || record.userID != userID {
+			rejected = append(rejected, shortURL)
+			continue
+		}
+
+		if !record.isDeleted {
+			continue
+		}
+
+		err := s.write(&storageData{
+			Operation: operationRestore,
+			ShortURL:  shortURL,
+			UserID:    userID,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.index.restore(userID, shortURL)
+	}
+
+	return rejected, nil
+}
+
+func (s *fileStorage) DeleteExpired(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.purge(s.index.expired(expiredBefore))
+}
+
+func (s *fileStorage) DeleteDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.purge(s.index.deleted(deletedBefore))
+}
+
+func (s *fileStorage) NextCounter(ctx context.Context) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.index.counter >= s.reserved {
+		err := s.write(&storageData{
+			Operation: operationCounter,
+			Counter:   s.reserved + counterBlockSize,
+		})
+
+		if err != nil {
+			return 0, err
+		}
+
+		s.reserved += counterBlockSize
+	}
+
+	s.index.counter++
+	return s.index.counter, nil
+}
+
+func (s *fileStorage) purge(shortURLs []string) (int64, error) {
+	var count int64
+
+	for _, shortURL := range shortURLs {
+		err := s.write(&storageData{
+			Operation: operationPurge,
+			ShortURL:  shortURL,
+		})
+
+		if err != nil {
+			return count, err
+		}
+
+		s.index.purge(shortURL)
+		count++
+	}
+
+	return count, nil
+}
+
+func (s *fileStorage) write(data *storageData) error {
+	bytes, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(bytes, '\n'))
+	return err
+}
+
+// readLines calls handle with every complete line of file. A torn last line,
+// left by a crash in the middle of a write, is truncated so that the next
+// append starts on a line of its own.
+func readLines(file *os.File, handle func(line []byte) error) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		bytes, err := reader.ReadBytes('\n')
+
+		if err == io.EOF {
+			if len(bytes) == 0 {
+				return nil
+			}
+
+			log.Printf("%s: truncating a torn record of %d bytes\n", file.Name(), len(bytes))
+			return file.Truncate(offset)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err = handle(bytes); err != nil {
+			return err
+		}
+
+		offset += int64(len(bytes))
+	}
+}
+
+// syncCloser flushes the file to disk before closing it so that records
+// written right before shutdown are not lost.
+type syncCloser struct {
+	file *os.File
+}
+
+func (c syncCloser) Close() error {
+	if err := c.file.Sync(); err != nil {
+		c.file.Close()
+		return err
+	}
+
+	return c.file.Close()
+}
+
+func newSaveData(input URLInput, createdAt time.Time) *storageData {
+	data := &storageData{
+		ShortURL:  input.ShortURL,
+		FullURL:   input.FullURL,
+		UserID:    input.UserID,
+		CreatedAt: &createdAt,
+	}
+
+	if !input.ExpiresAt.IsZero() {
+		data.ExpiresAt = &input.ExpiresAt
+	}
+
+	if !input.Options.IsZero() {
+		data.Options = &input.Options
+	}
+
+	return data
 }
 
-func (s *fileStorage) DeleteBatch(input []DeleteURLInput) error {
-	return errors.New("method not implemented")
+func replay(index *inMemoryStorage, data *storageData) {
+	switch data.Operation {
+	case operationSave:
+		input := URLInput{
+			ShortURL: data.ShortURL,
+			FullURL:  data.FullURL,
+			UserID:   data.UserID,
+		}
+
+		if data.ExpiresAt != nil {
+			input.ExpiresAt = *data.ExpiresAt
+		}
+
+		if data.Options != nil {
+			input.Options = *data.Options
+		}
+
+		// records written before creation times were recorded count every click
+		var createdAt time.Time
+
+		if data.CreatedAt != nil {
+			createdAt = *data.CreatedAt
+		}
+
+		index.insert(input, createdAt)
+	case operationBatch:
+		for i := range data.Items {
+			replay(index, &data.Items[i])
+		}
+	case operationDelete:
+		// tombstones written before deletion times were recorded start their
+		// retention window at startup
+		deletedAt := time.Now()
+
+		if data.DeletedAt != nil {
+			deletedAt = *data.DeletedAt
+		}
+
+		index.markDeleted(DeleteURLInput{
+			UserID: data.UserID,
+			URL:    data.ShortURL,
+		}, deletedAt)
+	case operationRestore:
+		index.restore(data.UserID, data.ShortURL)
+	case operationPurge:
+		index.purge(data.ShortURL)
+	case operationCounter:
+		// every counter up to the reserved one may have been handed out
+		if data.Counter > index.counter {
+			index.counter = data.Counter
+		}
+	}
 }
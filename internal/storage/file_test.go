@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorageReplaysTombstones(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	storage, closer, err := NewFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = storage.SaveBatch(ctx, []URLInput{
+		{ShortURL: "first", FullURL: "https://example.com/1", UserID: "user"},
+		{ShortURL: "second", FullURL: "https://example.com/2", UserID: "user", Options: LinkOptions{RedirectMode: "308"}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.DeleteBatch([]DeleteURLInput{{UserID: "user", URL: "first"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	closer.Close()
+	storage, closer, err = NewFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer closer.Close()
+
+	if _, err = storage.GetURL(ctx, "first"); !errors.Is(err, ErrIsDeleted) {
+		t.Errorf("expected ErrIsDeleted, got %v", err)
+	}
+
+	link, err := storage.GetLink(ctx, "second")
+
+	if err != nil || link.FullURL != "https://example.com/2" || link.Options.RedirectMode != "308" {
+		t.Errorf("unexpected result: %+v, %v", link, err)
+	}
+
+	shortURL, err := storage.GetByOriginalURL(ctx, "https://example.com/2")
+
+	if err != nil || shortURL != "second" {
+		t.Errorf("unexpected result: %q, %v", shortURL, err)
+	}
+
+	err = storage.SaveURL(ctx, URLInput{ShortURL: "third", FullURL: "https://example.com/1", UserID: "user"})
+
+	if !errors.Is(err, ErrAlreadyExist) {
+		t.Errorf("expected ErrAlreadyExist, got %v", err)
+	}
+}
+
+func TestFileStorageReplaysRestores(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	storage, closer, err := NewFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.SaveURL(ctx, URLInput{ShortURL: "first", FullURL: "https://example.com/1", UserID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.DeleteBatch([]DeleteURLInput{{UserID: "user", URL: "first"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.RestoreBatch(ctx, "user", []string{"first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	closer.Close()
+	storage, closer, err = NewFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer closer.Close()
+	fullURL, err := storage.GetURL(ctx, "first")
+
+	if err != nil || fullURL != "https://example.com/1" {
+		t.Errorf("unexpected result: %q, %v", fullURL, err)
+	}
+
+	if count, err := storage.DeleteDeleted(ctx, time.Now().Add(time.Hour)); err != nil || count != 0 {
+		t.Errorf("restored url must not be purged: %d, %v", count, err)
+	}
+}
+
+func TestFileAPIKeyStorageReplaysRevocations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json.keys")
+	keys, closer, err := NewFileAPIKeyStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []APIKey{{ID: "kept", UserID: "user", Hash: "hash-1"}, {ID: "revoked", UserID: "user", Hash: "hash-2"}} {
+		if err = keys.SaveAPIKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = keys.RevokeAPIKey(ctx, "other", "kept"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound revoking another user's key, got %v", err)
+	}
+
+	if err = keys.RevokeAPIKey(ctx, "user", "revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	closer.Close()
+	keys, closer, err = NewFileAPIKeyStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer closer.Close()
+
+	if userID, err := keys.GetUserIDByAPIKeyHash(ctx, "hash-1"); err != nil || userID != "user" {
+		t.Errorf("unexpected result: %q, %v", userID, err)
+	}
+
+	if _, err = keys.GetUserIDByAPIKeyHash(ctx, "hash-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a revoked key, got %v", err)
+	}
+}
+
+func TestFileStorageTruncatesTornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	storage, closer, err := NewFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.SaveURL(ctx, URLInput{ShortURL: "first", FullURL: "https://example.com/1", UserID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	closer.Close()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash in the middle of a write leaves a record without its newline
+	if _, err = file.WriteString(`{"op":"batch","items":[{"shortUrl":"lost"`); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Close()
+
+	for _, shortURL := range []string{"second", "third"} {
+		storage, closer, err = NewFileStorage(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = storage.SaveURL(ctx, URLInput{ShortURL: shortURL, FullURL: "https://example.com/" + shortURL, UserID: "user"})
+		closer.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage, closer, err = NewFileStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer closer.Close()
+
+	for _, shortURL := range []string{"first", "second", "third"} {
+		if _, err = storage.GetURL(ctx, shortURL); err != nil {
+			t.Errorf("%s: expected the record to survive, got %v", shortURL, err)
+		}
+	}
+
+	if _, err = storage.GetURL(ctx, "lost"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the torn record to be dropped, got %v", err)
+	}
+}
+
+func TestFileClickStorageDeletesClicks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clicks.json")
+	clicks, closer, err := NewFileClickStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	err = clicks.SaveClicks(ctx, []Click{
+		{ShortURL: "old", Timestamp: now.Add(-2 * time.Hour)},
+		{ShortURL: "kept", Timestamp: now.Add(-2 * time.Hour)},
+		{ShortURL: "kept", Timestamp: now},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := clicks.DeleteClicks(ctx, now.Add(-time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 {
+		t.Errorf("expected 2 deleted clicks, got %d", count)
+	}
+
+	if err = clicks.SaveClicks(ctx, []Click{{ShortURL: "kept", Timestamp: now}}); err != nil {
+		t.Fatal(err)
+	}
+
+	closer.Close()
+	clicks, closer, err = NewFileClickStorage(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer closer.Close()
+
+	for shortURL, expected := range map[string]int{"old": 0, "kept": 2} {
+		stats, err := clicks.GetStats(ctx, shortURL, time.Time{}, ClickBucketDay, 10)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Total != expected {
+			t.Errorf("%s: expected %d clicks after reopening, got %d", shortURL, expected, stats.Total)
+		}
+	}
+
+	// clicks before since belong to an earlier url with the same short url
+	stats, err := clicks.GetStats(ctx, "kept", now.Add(time.Second), ClickBucketDay, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Total != 0 {
+		t.Errorf("expected no clicks since the url was created, got %d", stats.Total)
+	}
+}
@@ -2,51 +2,311 @@ package storage
 
 import (
 	"context"
-	"errors"
 	"sync"
+	"time"
 )
 
 type inMemoryStorage struct {
-	mutex    sync.Mutex
-	urls     map[string]string
-	userData map[string][]UserData
+	mutex     sync.Mutex
+	urls      map[string]urlRecord
+	originals map[string]string
+	userData  map[string][]UserData
+	counter   uint64
+}
+
+type urlRecord struct {
+	fullURL   string
+	userID    string
+	expiresAt time.Time
+	options   LinkOptions
+	isDeleted bool
+	deletedAt time.Time
+}
+
+func (r urlRecord) isExpired(now time.Time) bool {
+	return !r.expiresAt.IsZero() && !r.expiresAt.After(now)
 }
 
 func NewInMemoryStorage() Storage {
+	return newInMemoryStorage()
+}
+
+func newInMemoryStorage() *inMemoryStorage {
 	return &inMemoryStorage{
-		urls:     make(map[string]string),
-		userData: make(map[string][]UserData),
-		mutex:    sync.Mutex{},
+		urls:      make(map[string]urlRecord),
+		originals: make(map[string]string),
+		userData:  make(map[string][]UserData),
+		mutex:     sync.Mutex{},
 	}
 }
 
 func (storage *inMemoryStorage) SaveURL(ctx context.Context, input URLInput) error {
 	storage.mutex.Lock()
-	storage.urls[input.ShortURL] = input.FullURL
-	storage.userData[input.UserID] = append(storage.userData[input.UserID], UserData{
-		ShortURL: input.ShortURL,
-		FullURL:  input.FullURL,
-	})
-	storage.mutex.Unlock()
+	defer storage.mutex.Unlock()
+
+	if err := storage.checkInsert([]URLInput{input}); err != nil {
+		return err
+	}
+
+	storage.insert(input, time.Now())
 	return nil
 }
 
 func (storage *inMemoryStorage) GetURL(ctx context.Context, shortURL string) (string, error) {
-	return storage.urls[shortURL], nil
+	link, err := storage.GetLink(ctx, shortURL)
+	return link.FullURL, err
+}
+
+func (storage *inMemoryStorage) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.get(shortURL, time.Now())
 }
 
 func (storage *inMemoryStorage) GetURLsByUserID(ctx context.Context, userID string) ([]UserData, error) {
-	return storage.userData[userID], nil
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return append([]UserData(nil), storage.userData[userID]...), nil
 }
 
 func (storage *inMemoryStorage) SaveBatch(ctx context.Context, batchData []URLInput) error {
-	return errors.New("method not implemented")
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if err := storage.checkInsert(batchData); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, input := range batchData {
+		storage.insert(input, now)
+	}
+
+	return nil
 }
 
 func (storage *inMemoryStorage) GetByOriginalURL(ctx context.Context, originalURL string) (string, error) {
-	return "", errors.New("method not implemented")
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.getByOriginal(originalURL, time.Now())
 }
 
-func (storage *inMemoryStorage) DeleteBatch(input []DeleteURLInput) error {
-	return errors.New("method not implemented")
+func (storage *inMemoryStorage) DeleteBatch(input []DeleteURLInput) ([]DeleteURLInput, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	var rejected []DeleteURLInput
+	now := time.Now()
+
+	for _, data := range input {
+		if !storage.markDeleted(data, now) {
+			rejected = append(rejected, data)
+		}
+	}
+
+	return rejected, nil
+}
+
+func (storage *inMemoryStorage) RestoreBatch(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	var rejected []string
+
+	for _, shortURL := range shortURLs {
+		if !storage.restore(userID, shortURL) {
+			rejected = append(rejected, shortURL)
+		}
+	}
+
+	return rejected, nil
+}
+
+func (storage *inMemoryStorage) DeleteExpired(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	expired := storage.expired(expiredBefore)
+
+	for _, shortURL := range expired {
+		storage.purge(shortURL)
+	}
+
+	return int64(len(expired)), nil
+}
+
+func (storage *inMemoryStorage) DeleteDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	deleted := storage.deleted(deletedBefore)
+
+	for _, shortURL := range deleted {
+		storage.purge(shortURL)
+	}
+
+	return int64(len(deleted)), nil
+}
+
+func (storage *inMemoryStorage) NextCounter(ctx context.Context) (uint64, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.counter++
+	return storage.counter, nil
+}
+
+// The helpers below expect the caller to hold the mutex; fileStorage reuses them
+// so that both backends share a single implementation of the index.
+
+func (storage *inMemoryStorage) checkInsert(batchData []URLInput) error {
+	shortURLs := make(map[string]bool, len(batchData))
+	originals := make(map[string]bool, len(batchData))
+	now := time.Now()
+
+	for _, input := range batchData {
+		if _, ok := storage.urls[input.ShortURL]; ok || shortURLs[input.ShortURL] {
+			return ErrShortURLAlreadyExist
+		}
+
+		if _, err := storage.getByOriginal(input.FullURL, now); err == nil || originals[input.FullURL] {
+			return ErrAlreadyExist
+		}
+
+		shortURLs[input.ShortURL] = true
+		originals[input.FullURL] = true
+	}
+
+	return nil
+}
+
+// insert replaces an expired url with the same original url, so that the
+// original url can be shortened again before the expired one is purged.
+func (storage *inMemoryStorage) insert(input URLInput, createdAt time.Time) {
+	if shortURL, ok := storage.originals[input.FullURL]; ok && storage.urls[shortURL].isExpired(time.Now()) {
+		storage.purge(shortURL)
+	}
+
+	storage.urls[input.ShortURL] = urlRecord{
+		fullURL:   input.FullURL,
+		userID:    input.UserID,
+		expiresAt: input.ExpiresAt,
+		options:   input.Options,
+	}
+	storage.originals[input.FullURL] = input.ShortURL
+	storage.userData[input.UserID] = append(storage.userData[input.UserID], UserData{
+		ShortURL:  input.ShortURL,
+		FullURL:   input.FullURL,
+		CreatedAt: createdAt,
+	})
+}
+
+// getByOriginal skips expired urls: they are replaced when the original url is
+// shortened again.
+func (storage *inMemoryStorage) getByOriginal(originalURL string, now time.Time) (string, error) {
+	shortURL, ok := storage.originals[originalURL]
+
+	if !ok || storage.urls[shortURL].isExpired(now) {
+		return "", ErrNotFound
+	}
+
+	return shortURL, nil
+}
+
+func (storage *inMemoryStorage) get(shortURL string, now time.Time) (Link, error) {
+	record, ok := storage.urls[shortURL]
+
+	if !ok {
+		return Link{}, ErrNotFound
+	}
+
+	if record.isDeleted {
+		return Link{}, ErrIsDeleted
+	}
+
+	if record.isExpired(now) {
+		return Link{}, ErrIsExpired
+	}
+
+	return Link{FullURL: record.fullURL, Options: record.options, ExpiresAt: record.expiresAt}, nil
+}
+
+// markDeleted reports whether the url is owned by the user; deleting an already
+// deleted url is not an error and keeps the original deletion time.
+func (storage *inMemoryStorage) markDeleted(data DeleteURLInput, deletedAt time.Time) bool {
+	record, ok := storage.urls[data.URL]
+
+	if !ok || record.userID != data.UserID {
+		return false
+	}
+
+	if !record.isDeleted {
+		record.isDeleted = true
+		record.deletedAt = deletedAt
+		storage.urls[data.URL] = record
+	}
+
+	return true
+}
+
+// restore reports whether the url is owned by the user; restoring a url that
+// is not deleted is not an error.
+func (storage *inMemoryStorage) restore(userID string, shortURL string) bool {
+	record, ok := storage.urls[shortURL]
+
+	if !ok || record.userID != userID {
+		return false
+	}
+
+	record.isDeleted = false
+	record.deletedAt = time.Time{}
+	storage.urls[shortURL] = record
+	return true
+}
+
+func (storage *inMemoryStorage) expired(expiredBefore time.Time) []string {
+	var result []string
+
+	for shortURL, record := range storage.urls {
+		if record.isExpired(expiredBefore) {
+			result = append(result, shortURL)
+		}
+	}
+
+	return result
+}
+
+func (storage *inMemoryStorage) deleted(deletedBefore time.Time) []string {
+	var result []string
+
+	for shortURL, record := range storage.urls {
+		if record.isDeleted && record.deletedAt.Before(deletedBefore) {
+			result = append(result, shortURL)
+		}
+	}
+
+	return result
+}
+
+func (storage *inMemoryStorage) purge(shortURL string) {
+	record, ok := storage.urls[shortURL]
+
+	if !ok {
+		return
+	}
+
+	delete(storage.urls, shortURL)
+
+	if storage.originals[record.fullURL] == shortURL {
+		delete(storage.originals, record.fullURL)
+	}
+
+	items := storage.userData[record.userID]
+
+	for index, item := range items {
+		if item.ShortURL == shortURL {
+			storage.userData[record.userID] = append(items[:index:index], items[index+1:]...)
+			break
+		}
+	}
+
+	if len(storage.userData[record.userID]) == 0 {
+		delete(storage.userData, record.userID)
+	}
 }
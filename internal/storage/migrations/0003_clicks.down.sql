@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "clicks";
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "clicks" (
+  "id" bigserial PRIMARY KEY,
+  "short_url" varchar NOT NULL,
+  "clicked_at" timestamptz NOT NULL,
+  "referrer" varchar NOT NULL DEFAULT '',
+  "user_agent" varchar NOT NULL DEFAULT '',
+  "client_ip" varchar NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks (short_url, clicked_at);
\ No newline at end of file
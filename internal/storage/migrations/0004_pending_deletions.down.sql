@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "pending_deletions";
\ No newline at end of file
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS "pending_deletions" (
+  "user_id" varchar NOT NULL,
+  "short_url" varchar NOT NULL,
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  PRIMARY KEY ("user_id", "short_url")
+);
\ No newline at end of file
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS "deletion_jobs_status_updated_at_idx";
+DELETE FROM "deletion_jobs" WHERE "status" <> 'pending';
+ALTER TABLE "deletion_jobs" DROP CONSTRAINT "deletion_jobs_pkey";
+DELETE FROM "deletion_jobs" a USING "deletion_jobs" b
+  WHERE a."user_id" = b."user_id" AND a."short_url" = b."short_url" AND a."job_id" > b."job_id";
+ALTER TABLE "deletion_jobs" DROP COLUMN "updated_at";
+ALTER TABLE "deletion_jobs" DROP COLUMN "status";
+ALTER TABLE "deletion_jobs" DROP COLUMN "job_id";
+ALTER TABLE "deletion_jobs" RENAME TO "pending_deletions";
+ALTER TABLE "pending_deletions" ADD PRIMARY KEY ("user_id", "short_url");
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE "pending_deletions" RENAME TO "deletion_jobs";
+ALTER TABLE "deletion_jobs" DROP CONSTRAINT "pending_deletions_pkey";
+ALTER TABLE "deletion_jobs" ADD COLUMN "job_id" varchar NOT NULL DEFAULT '';
+ALTER TABLE "deletion_jobs" ADD COLUMN "status" varchar NOT NULL DEFAULT 'pending';
+ALTER TABLE "deletion_jobs" ADD COLUMN "updated_at" timestamptz NOT NULL DEFAULT (now());
+ALTER TABLE "deletion_jobs" ADD PRIMARY KEY ("job_id", "user_id", "short_url");
+CREATE INDEX IF NOT EXISTS "deletion_jobs_status_updated_at_idx" ON "deletion_jobs" ("status", "updated_at");
\ No newline at end of file
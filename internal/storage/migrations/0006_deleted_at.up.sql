@@ -0,0 +1,5 @@
+ALTER TABLE "urls" ADD COLUMN IF NOT EXISTS "deleted_at" timestamptz;
+
+UPDATE "urls" SET "deleted_at" = now() WHERE "is_deleted" = 1;
+
+CREATE INDEX IF NOT EXISTS urls_deleted_at_idx ON urls (deleted_at) WHERE deleted_at IS NOT NULL;
\ No newline at end of file
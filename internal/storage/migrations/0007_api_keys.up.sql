@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "api_keys" (
+  "id" varchar PRIMARY KEY,
+  "user_id" varchar NOT NULL,
+  "key_hash" varchar NOT NULL,
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  "revoked_at" timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_key_hash_idx ON api_keys (key_hash);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE "urls" DROP COLUMN IF EXISTS "options";
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE "urls" ADD COLUMN IF NOT EXISTS "options" jsonb;
\ No newline at end of file
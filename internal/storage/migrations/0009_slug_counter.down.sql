@@ -0,0 +1 @@
+DROP SEQUENCE IF EXISTS "short_url_counter";
\ No newline at end of file
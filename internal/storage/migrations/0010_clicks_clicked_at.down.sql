@@ -0,0 +1 @@
+DROP INDEX IF EXISTS clicks_clicked_at_idx;
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS clicks_clicked_at_idx ON clicks (clicked_at);
\ No newline at end of file
@@ -3,31 +3,268 @@ package migrations
 import (
 	"context"
 	"database/sql"
-	"os"
+	"embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
 )
 
+// advisoryLockID serializes migrations between replicas sharing one database.
+const advisoryLockID = 7235094168
+
+//go:embed *.sql
+var files embed.FS
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
 func Migrate(ctx context.Context, db *sql.DB) error {
-	file, err := os.OpenFile("internal/storage/migrations/initial.sql", os.O_RDONLY, 0777)
+	return Up(ctx, db)
+}
+
+func Up(ctx context.Context, db *sql.DB) error {
+	migrations, err := load()
+
+	if err != nil {
+		return err
+	}
+
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+
+			err = execInTx(ctx, conn, m.up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", m.version, m.name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down rolls back the most recently applied migration.
+func Down(ctx context.Context, db *sql.DB) error {
+	migrations, err := load()
+
+	if err != nil {
+		return err
+	}
+
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+
+			err = execInTx(ctx, conn, m.down, "DELETE FROM schema_migrations WHERE version=$1", m.version)
+
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", m.version, m.name, err)
+			}
+
+			return nil
+		}
+
+		return nil
+	})
+}
+
+func GetStatus(ctx context.Context, db *sql.DB) ([]Status, error) {
+	migrations, err := load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Status
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			status := Status{
+				Version: m.version,
+				Name:    m.name,
+			}
+
+			if appliedAt, ok := applied[m.version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+
+			result = append(result, status)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func load() ([]migration, error) {
+	entries, err := files.ReadDir(".")
+
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+
+	for _, entry := range entries {
+		matches := fileNamePattern.FindStringSubmatch(entry.Name())
+
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		script, err := files.ReadFile(entry.Name())
+
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+
+		if !ok {
+			m = &migration{version: version, name: matches[2]}
+			byVersion[version] = m
+		}
+
+		if matches[3] == "up" {
+			m.up = string(script)
+		} else {
+			m.down = string(script)
+		}
+	}
+
+	result := make([]migration, 0, len(byVersion))
+
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down scripts", m.version, m.name)
+		}
+		result = append(result, *m)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+
+	return result, nil
+}
+
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
 
 	if err != nil {
 		return err
 	}
 
-	defer file.Close()
-	info, err := file.Stat()
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", advisoryLockID); err != nil {
+		return err
+	}
+
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS "schema_migrations" (
+  "version" bigint PRIMARY KEY,
+  "name" varchar NOT NULL,
+  "applied_at" timestamptz NOT NULL DEFAULT (now())
+)`)
 
 	if err != nil {
 		return err
 	}
 
-	bytes := make([]byte, info.Size())
-	_, err = file.Read(bytes)
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	result := make(map[int64]time.Time)
+
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
 
+	err = rows.Err()
 	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func execInTx(ctx context.Context, conn *sql.Conn, script string, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, bookkeeping, args...); err != nil {
 		return err
 	}
 
-	sqlMigration := string(bytes)
-	_, err = db.ExecContext(ctx, sqlMigration)
-	return err
+	return tx.Commit()
 }
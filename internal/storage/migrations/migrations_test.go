@@ -0,0 +1,25 @@
+package migrations
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	migrations, err := load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	for i, m := range migrations {
+		if m.version != int64(i+1) {
+			t.Errorf("expected version %d, got %d (%s)", i+1, m.version, m.name)
+		}
+
+		if m.up == "" || m.down == "" {
+			t.Errorf("migration %d_%s has an empty script", m.version, m.name)
+		}
+	}
+}
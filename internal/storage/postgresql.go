@@ -3,7 +3,9 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/iamsorryprincess/url-shortener/internal/storage/migrations"
 	"github.com/jackc/pgx"
@@ -26,31 +28,70 @@ func NewPostgresqlStorage(db *sql.DB) (Storage, error) {
 }
 
 func (s *postgresqlStorage) SaveURL(ctx context.Context, input URLInput) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO public.urls (short_url, original_url, user_id) VALUES ($1, $2, $3);", input.ShortURL, input.FullURL, input.UserID)
+	options, err := nullOptions(input.Options)
 
-	var pgError pgx.PgError
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 
 	if err != nil {
-		if errors.As(err, &pgError) && pgError.Code == "23505" {
-			return ErrAlreadyExist
-		}
 		return err
 	}
 
-	return nil
+	defer tx.Rollback()
+
+	if err = replaceExpired(ctx, tx, input.FullURL); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO public.urls (short_url, original_url, user_id, expires_at, options) VALUES ($1, $2, $3, $4, $5);",
+		input.ShortURL, input.FullURL, input.UserID, nullTime(input.ExpiresAt), options)
+
+	if err != nil {
+		return mapUniqueViolation(err)
+	}
+
+	return tx.Commit()
 }
 
 func (s *postgresqlStorage) GetURL(ctx context.Context, shortURL string) (string, error) {
-	result := ""
+	link, err := s.GetLink(ctx, shortURL)
+	return link.FullURL, err
+}
+
+func (s *postgresqlStorage) GetLink(ctx context.Context, shortURL string) (Link, error) {
+	result := Link{}
 	isDeleted := 0
-	err := s.db.QueryRowContext(ctx, "SELECT original_url, is_deleted FROM public.urls WHERE short_url=$1", shortURL).Scan(&result, &isDeleted)
+	var expiresAt sql.NullTime
+	var options sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT original_url, is_deleted, expires_at, options FROM public.urls WHERE short_url=$1", shortURL).
+		Scan(&result.FullURL, &isDeleted, &expiresAt, &options)
 
 	if err != nil {
-		return "", err
+		if errors.Is(err, sql.ErrNoRows) {
+			return Link{}, ErrNotFound
+		}
+		return Link{}, err
 	}
 
 	if isDeleted == 1 {
-		return "", ErrIsDeleted
+		return Link{}, ErrIsDeleted
+	}
+
+	if expiresAt.Valid {
+		if !expiresAt.Time.After(time.Now()) {
+			return Link{}, ErrIsExpired
+		}
+
+		result.ExpiresAt = expiresAt.Time
+	}
+
+	if options.Valid {
+		if err = json.Unmarshal([]byte(options.String), &result.Options); err != nil {
+			return Link{}, err
+		}
 	}
 
 	return result, nil
@@ -64,7 +105,7 @@ func (s *postgresqlStorage) SaveBatch(ctx context.Context, input []URLInput) err
 	}
 
 	defer tx.Rollback()
-	stmt, err := tx.PrepareContext(ctx, "INSERT INTO public.urls (short_url, original_url, user_id) VALUES ($1, $2, $3)")
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO public.urls (short_url, original_url, user_id, expires_at, options) VALUES ($1, $2, $3, $4, $5)")
 
 	if err != nil {
 		return err
@@ -73,11 +114,21 @@ func (s *postgresqlStorage) SaveBatch(ctx context.Context, input []URLInput) err
 	defer stmt.Close()
 
 	for _, inputData := range input {
-		_, err = stmt.ExecContext(ctx, inputData.ShortURL, inputData.FullURL, inputData.UserID)
+		options, err := nullOptions(inputData.Options)
 
 		if err != nil {
 			return err
 		}
+
+		if err = replaceExpired(ctx, tx, inputData.FullURL); err != nil {
+			return err
+		}
+
+		_, err = stmt.ExecContext(ctx, inputData.ShortURL, inputData.FullURL, inputData.UserID, nullTime(inputData.ExpiresAt), options)
+
+		if err != nil {
+			return mapUniqueViolation(err)
+		}
 	}
 
 	return tx.Commit()
@@ -85,9 +136,12 @@ func (s *postgresqlStorage) SaveBatch(ctx context.Context, input []URLInput) err
 
 func (s *postgresqlStorage) GetByOriginalURL(ctx context.Context, originalURL string) (string, error) {
 	result := ""
-	err := s.db.QueryRowContext(ctx, "SELECT short_url FROM public.urls WHERE original_url=$1", originalURL).Scan(&result)
+	err := s.db.QueryRowContext(ctx, "SELECT short_url FROM public.urls WHERE original_url=$1 AND (expires_at IS NULL OR expires_at > now())", originalURL).Scan(&result)
 
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
 		return "", err
 	}
 
@@ -95,7 +149,7 @@ func (s *postgresqlStorage) GetByOriginalURL(ctx context.Context, originalURL st
 }
 
 func (s *postgresqlStorage) GetURLsByUserID(ctx context.Context, userID string) ([]UserData, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT short_url, original_url FROM public.urls WHERE user_id=$1", userID)
+	rows, err := s.db.QueryContext(ctx, "SELECT short_url, original_url, created_at FROM public.urls WHERE user_id=$1", userID)
 
 	if err != nil {
 		return nil, err
@@ -106,7 +160,7 @@ func (s *postgresqlStorage) GetURLsByUserID(ctx context.Context, userID string)
 
 	for rows.Next() {
 		var userData UserData
-		err = rows.Scan(&userData.ShortURL, &userData.FullURL)
+		err = rows.Scan(&userData.ShortURL, &userData.FullURL, &userData.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -121,29 +175,145 @@ func (s *postgresqlStorage) GetURLsByUserID(ctx context.Context, userID string)
 	return result, nil
 }
 
-func (s *postgresqlStorage) DeleteBatch(input []DeleteURLInput) error {
+func (s *postgresqlStorage) DeleteBatch(input []DeleteURLInput) ([]DeleteURLInput, error) {
 	tx, err := s.db.Begin()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer tx.Rollback()
-	stmt, err := tx.Prepare("UPDATE public.urls SET is_deleted='1' WHERE user_id=$1 AND short_url=$2")
+	stmt, err := tx.Prepare("UPDATE public.urls SET is_deleted='1', deleted_at=COALESCE(deleted_at, now()) WHERE user_id=$1 AND short_url=$2")
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer stmt.Close()
+	var rejected []DeleteURLInput
 
 	for _, data := range input {
-		_, err = stmt.Exec(data.UserID, data.URL)
+		result, err := stmt.Exec(data.UserID, data.URL)
 
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		affected, err := result.RowsAffected()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if affected == 0 {
+			rejected = append(rejected, data)
 		}
 	}
 
-	return tx.Commit()
+	return rejected, tx.Commit()
+}
+
+func (s *postgresqlStorage) RestoreBatch(ctx context.Context, userID string, shortURLs []string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, "UPDATE public.urls SET is_deleted='0', deleted_at=NULL WHERE user_id=$1 AND short_url=$2")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer stmt.Close()
+	var rejected []string
+
+	for _, shortURL := range shortURLs {
+		result, err := stmt.ExecContext(ctx, userID, shortURL)
+
+		if err != nil {
+			return nil, err
+		}
+
+		affected, err := result.RowsAffected()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if affected == 0 {
+			rejected = append(rejected, shortURL)
+		}
+	}
+
+	return rejected, tx.Commit()
+}
+
+func (s *postgresqlStorage) DeleteExpired(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM public.urls WHERE expires_at IS NOT NULL AND expires_at <= $1", expiredBefore)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *postgresqlStorage) DeleteDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM public.urls WHERE is_deleted=1 AND deleted_at < $1", deletedBefore)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *postgresqlStorage) NextCounter(ctx context.Context) (uint64, error) {
+	var result int64
+	err := s.db.QueryRowContext(ctx, "SELECT nextval('short_url_counter')").Scan(&result)
+	return uint64(result), err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{
+		Time:  t,
+		Valid: !t.IsZero(),
+	}
+}
+
+func nullOptions(options LinkOptions) (sql.NullString, error) {
+	if options.IsZero() {
+		return sql.NullString{}, nil
+	}
+
+	bytes, err := json.Marshal(options)
+
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: string(bytes), Valid: true}, nil
+}
+
+// replaceExpired deletes an expired url with the given original url, so that
+// the original url can be shortened again before the sweeper purges it.
+func replaceExpired(ctx context.Context, tx *sql.Tx, originalURL string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM public.urls WHERE original_url=$1 AND expires_at <= now()", originalURL)
+	return err
+}
+
+func mapUniqueViolation(err error) error {
+	var pgError pgx.PgError
+
+	if errors.As(err, &pgError) && pgError.Code == "23505" {
+		if pgError.ConstraintName == "urls_pkey" {
+			return ErrShortURLAlreadyExist
+		}
+		return ErrAlreadyExist
+	}
+
+	return err
 }
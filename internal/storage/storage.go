@@ -3,23 +3,84 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var ErrAlreadyExist = errors.New("original url already exist")
+var ErrShortURLAlreadyExist = errors.New("short url already exist")
 var ErrIsDeleted = errors.New("is deleted")
+var ErrNotFound = errors.New("not found")
+var ErrIsExpired = errors.New("is expired")
 
 type URLInput struct {
-	ShortURL string
-	FullURL  string
-	UserID   string
+	ShortURL  string
+	FullURL   string
+	UserID    string
+	ExpiresAt time.Time
+	Options   LinkOptions
+}
+
+// LinkOptions control how a short url redirects. They are stored as a single
+// JSON document, so new options need no schema changes.
+type LinkOptions struct {
+	// RedirectMode is one of the service redirect modes; empty means the
+	// server default.
+	RedirectMode string `json:"redirect_mode,omitempty"`
+	// InterstitialDelay is the number of seconds the interstitial page waits
+	// before redirecting.
+	InterstitialDelay int `json:"interstitial_delay,omitempty"`
+	// QueryMerge is one of the service query merge modes; empty drops the
+	// query of the redirect request.
+	QueryMerge string `json:"query_merge,omitempty"`
+	// Template marks FullURL as a template with placeholders that are filled
+	// in on every redirect.
+	Template bool `json:"template,omitempty"`
+	// Rules are evaluated in order on every redirect; the target of the first
+	// matching rule replaces FullURL.
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+func (o LinkOptions) IsZero() bool {
+	return o.RedirectMode == "" && o.InterstitialDelay == 0 && o.QueryMerge == "" && !o.Template && len(o.Rules) == 0
+}
+
+// Rule matches when every condition it sets matches; a condition with several
+// values matches any of them.
+type Rule struct {
+	Target     string      `json:"target"`
+	Devices    []string    `json:"devices,omitempty"`
+	Languages  []string    `json:"languages,omitempty"`
+	Countries  []string    `json:"countries,omitempty"`
+	TimeWindow *TimeWindow `json:"time_window,omitempty"`
+}
+
+// TimeWindow is a daily window from Start to End, both "15:04" in Location.
+// A window whose end is before its start spans midnight.
+type TimeWindow struct {
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Location string   `json:"location,omitempty"`
+	Weekdays []string `json:"weekdays,omitempty"`
+}
+
+// Link is the target of a short url together with its options.
+type Link struct {
+	FullURL string      `json:"full_url"`
+	Options LinkOptions `json:"options"`
+	// ExpiresAt is zero for urls that never expire.
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type UserData struct {
 	ShortURL string `json:"short_url"`
 	FullURL  string `json:"original_url"`
+	// CreatedAt tells clicks on the url from clicks on an earlier url that had
+	// the same short url; it is zero for urls stored before it was recorded.
+	CreatedAt time.Time `json:"-"`
 }
 
 type DeleteURLInput struct {
+	JobID  string
 	UserID string
 	URL    string
 }
@@ -27,8 +88,21 @@ type DeleteURLInput struct {
 type Storage interface {
 	SaveURL(ctx context.Context, input URLInput) error
 	GetURL(ctx context.Context, shortURL string) (string, error)
+	// GetLink is GetURL that also returns the options of the url.
+	GetLink(ctx context.Context, shortURL string) (Link, error)
 	GetURLsByUserID(ctx context.Context, userID string) ([]UserData, error)
 	SaveBatch(ctx context.Context, batchInput []URLInput) error
 	GetByOriginalURL(ctx context.Context, originalURL string) (string, error)
-	DeleteBatch(input []DeleteURLInput) error
+	// DeleteBatch soft-deletes urls and returns the items that were rejected
+	// because the url does not exist or belongs to another user.
+	DeleteBatch(input []DeleteURLInput) ([]DeleteURLInput, error)
+	// RestoreBatch undoes DeleteBatch and returns the short urls that were
+	// rejected because they do not exist or belong to another user.
+	RestoreBatch(ctx context.Context, userID string, shortURLs []string) ([]string, error)
+	DeleteExpired(ctx context.Context, expiredBefore time.Time) (int64, error)
+	// DeleteDeleted hard-deletes urls that were soft-deleted before deletedBefore.
+	DeleteDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
+	// NextCounter returns a number that was never returned before, including
+	// by earlier runs and by other instances sharing the storage.
+	NextCounter(ctx context.Context) (uint64, error)
 }
@@ -0,0 +1,108 @@
+package storage_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+	"github.com/iamsorryprincess/url-shortener/internal/storage/storagetest"
+	_ "github.com/jackc/pgx/stdlib"
+)
+
+func TestInMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewInMemoryStorage()
+	})
+}
+
+func TestCachedStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewCachedStorage(storage.NewInMemoryStorage(), storage.NewLRUCache(16), time.Minute, time.Minute)
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, closer, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "storage.json"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			closer.Close()
+		})
+
+		return s
+	})
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	storagetest.RunReopen(t, func(t *testing.T) func() storage.Storage {
+		path := filepath.Join(t.TempDir(), "storage.json")
+
+		return func() storage.Storage {
+			s, closer, err := storage.NewFileStorage(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Cleanup(func() {
+				closer.Close()
+			})
+
+			return s
+		}
+	})
+}
+
+// TestPostgresqlStorage runs against the database from TEST_DATABASE_DSN; the
+// database is truncated before every case.
+func TestPostgresqlStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	s, err := storage.NewPostgresqlStorage(db)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		if _, err := db.Exec("TRUNCATE public.urls"); err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	})
+	storagetest.RunReopen(t, func(t *testing.T) func() storage.Storage {
+		if _, err := db.Exec("TRUNCATE public.urls"); err != nil {
+			t.Fatal(err)
+		}
+
+		return func() storage.Storage {
+			s, err := storage.NewPostgresqlStorage(db)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return s
+		}
+	})
+}
@@ -0,0 +1,594 @@
+// Package storagetest provides a conformance suite that every storage.Storage
+// implementation is expected to pass.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+type Factory func(t *testing.T) storage.Storage
+
+// Opener returns a function that opens the same storage every time it is
+// called, so that a case can check what survives a restart.
+type Opener func(t *testing.T) func() storage.Storage
+
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{name: "save and get", test: testSaveAndGet},
+		{name: "get unknown url", test: testGetUnknown},
+		{name: "link options", test: testLinkOptions},
+		{name: "duplicate short url", test: testDuplicateShortURL},
+		{name: "duplicate original url", test: testDuplicateOriginalURL},
+		{name: "save batch", test: testSaveBatch},
+		{name: "save batch is atomic", test: testSaveBatchAtomic},
+		{name: "urls by user id", test: testURLsByUserID},
+		{name: "delete batch", test: testDeleteBatch},
+		{name: "restore batch", test: testRestoreBatch},
+		{name: "delete deleted", test: testDeleteDeleted},
+		{name: "expiration", test: testExpiration},
+		{name: "expired original url is replaced", test: testExpiredOriginalReplaced},
+		{name: "concurrent access", test: testConcurrentAccess},
+		{name: "counter", test: testCounter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStorage(t))
+		})
+	}
+}
+
+// RunReopen runs the cases for storages that keep their data between runs.
+func RunReopen(t *testing.T, newOpener Opener) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, open func() storage.Storage)
+	}{
+		{name: "counter survives reopen", test: testCounterSurvivesReopen},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newOpener(t))
+		})
+	}
+}
+
+func testSaveAndGet(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "abc", FullURL: "https://example.com/abc", UserID: "user"})
+	fullURL, err := s.GetURL(ctx, "abc")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fullURL != "https://example.com/abc" {
+		t.Errorf("expected https://example.com/abc, got %s", fullURL)
+	}
+}
+
+func testGetUnknown(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if _, err := s.GetURL(ctx, "unknown"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetURL: expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := s.GetByOriginalURL(ctx, "https://example.com/unknown"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetByOriginalURL: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testLinkOptions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	options := storage.LinkOptions{
+		RedirectMode:      "interstitial",
+		InterstitialDelay: 3,
+		Rules: []storage.Rule{{
+			Target:     "https://example.com/mobile",
+			Devices:    []string{"ios", "android"},
+			TimeWindow: &storage.TimeWindow{Start: "22:00", End: "06:00", Location: "UTC"},
+		}},
+	}
+	mustSave(t, s, storage.URLInput{ShortURL: "single", FullURL: "https://example.com/single", UserID: "user", Options: options})
+	mustSave(t, s, storage.URLInput{ShortURL: "plain", FullURL: "https://example.com/plain", UserID: "user"})
+	err := s.SaveBatch(ctx, []storage.URLInput{
+		{ShortURL: "batch", FullURL: "https://example.com/batch", UserID: "user", Options: options},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, shortURL := range []string{"single", "batch"} {
+		link, err := s.GetLink(ctx, shortURL)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if link.FullURL != "https://example.com/"+shortURL || !reflect.DeepEqual(link.Options, options) {
+			t.Errorf("unexpected link %s: %+v", shortURL, link)
+		}
+	}
+
+	if link, err := s.GetLink(ctx, "plain"); err != nil || !link.Options.IsZero() {
+		t.Errorf("expected a link without options, got %+v, %v", link, err)
+	}
+}
+
+func testDuplicateShortURL(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "abc", FullURL: "https://example.com/1", UserID: "user"})
+	err := s.SaveURL(ctx, storage.URLInput{ShortURL: "abc", FullURL: "https://example.com/2", UserID: "user"})
+
+	if !errors.Is(err, storage.ErrShortURLAlreadyExist) {
+		t.Errorf("expected ErrShortURLAlreadyExist, got %v", err)
+	}
+}
+
+func testDuplicateOriginalURL(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "first", FullURL: "https://example.com/", UserID: "user"})
+	err := s.SaveURL(ctx, storage.URLInput{ShortURL: "second", FullURL: "https://example.com/", UserID: "other"})
+
+	if !errors.Is(err, storage.ErrAlreadyExist) {
+		t.Errorf("expected ErrAlreadyExist, got %v", err)
+	}
+
+	shortURL, err := s.GetByOriginalURL(ctx, "https://example.com/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shortURL != "first" {
+		t.Errorf("expected first, got %s", shortURL)
+	}
+}
+
+func testSaveBatch(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	err := s.SaveBatch(ctx, []storage.URLInput{
+		{ShortURL: "one", FullURL: "https://example.com/1", UserID: "user"},
+		{ShortURL: "two", FullURL: "https://example.com/2", UserID: "user"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for shortURL, expected := range map[string]string{"one": "https://example.com/1", "two": "https://example.com/2"} {
+		fullURL, err := s.GetURL(ctx, shortURL)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fullURL != expected {
+			t.Errorf("expected %s, got %s", expected, fullURL)
+		}
+	}
+}
+
+func testSaveBatchAtomic(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "taken", FullURL: "https://example.com/taken", UserID: "user"})
+
+	batches := []struct {
+		name     string
+		input    []storage.URLInput
+		expected error
+	}{
+		{
+			name: "conflict with existing short url",
+			input: []storage.URLInput{
+				{ShortURL: "fresh1", FullURL: "https://example.com/fresh1", UserID: "user"},
+				{ShortURL: "taken", FullURL: "https://example.com/other", UserID: "user"},
+			},
+			expected: storage.ErrShortURLAlreadyExist,
+		},
+		{
+			name: "conflict with existing original url",
+			input: []storage.URLInput{
+				{ShortURL: "fresh2", FullURL: "https://example.com/fresh2", UserID: "user"},
+				{ShortURL: "fresh3", FullURL: "https://example.com/taken", UserID: "user"},
+			},
+			expected: storage.ErrAlreadyExist,
+		},
+		{
+			name: "duplicate short url inside batch",
+			input: []storage.URLInput{
+				{ShortURL: "fresh4", FullURL: "https://example.com/fresh4", UserID: "user"},
+				{ShortURL: "fresh4", FullURL: "https://example.com/fresh5", UserID: "user"},
+			},
+			expected: storage.ErrShortURLAlreadyExist,
+		},
+	}
+
+	for _, batch := range batches {
+		t.Run(batch.name, func(t *testing.T) {
+			err := s.SaveBatch(ctx, batch.input)
+
+			if !errors.Is(err, batch.expected) {
+				t.Fatalf("expected %v, got %v", batch.expected, err)
+			}
+
+			if _, err = s.GetURL(ctx, batch.input[0].ShortURL); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("batch was partially applied: GetURL(%s) returned %v", batch.input[0].ShortURL, err)
+			}
+		})
+	}
+}
+
+func testURLsByUserID(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "a1", FullURL: "https://example.com/a1", UserID: "alice"})
+	mustSave(t, s, storage.URLInput{ShortURL: "a2", FullURL: "https://example.com/a2", UserID: "alice"})
+	mustSave(t, s, storage.URLInput{ShortURL: "b1", FullURL: "https://example.com/b1", UserID: "bob"})
+
+	data, err := s.GetURLsByUserID(ctx, "alice")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].ShortURL < data[j].ShortURL
+	})
+
+	expected := []storage.UserData{
+		{ShortURL: "a1", FullURL: "https://example.com/a1"},
+		{ShortURL: "a2", FullURL: "https://example.com/a2"},
+	}
+
+	for index := range data {
+		if data[index].CreatedAt.IsZero() {
+			t.Errorf("expected a creation time for %s", data[index].ShortURL)
+		}
+
+		data[index].CreatedAt = time.Time{}
+	}
+
+	if fmt.Sprint(data) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, data)
+	}
+
+	data, err = s.GetURLsByUserID(ctx, "nobody")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 0 {
+		t.Errorf("expected no urls, got %v", data)
+	}
+}
+
+func testDeleteBatch(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "mine", FullURL: "https://example.com/mine", UserID: "alice"})
+	mustSave(t, s, storage.URLInput{ShortURL: "theirs", FullURL: "https://example.com/theirs", UserID: "bob"})
+
+	rejected, err := s.DeleteBatch([]storage.DeleteURLInput{
+		{UserID: "alice", URL: "mine"},
+		{UserID: "alice", URL: "theirs"},
+		{UserID: "alice", URL: "unknown"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].URL < rejected[j].URL
+	})
+
+	if len(rejected) != 2 || rejected[0].URL != "theirs" || rejected[1].URL != "unknown" {
+		t.Errorf("expected theirs and unknown to be rejected, got %v", rejected)
+	}
+
+	rejected, err = s.DeleteBatch([]storage.DeleteURLInput{{UserID: "alice", URL: "mine"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rejected) != 0 {
+		t.Errorf("deleting an already deleted url must not be rejected, got %v", rejected)
+	}
+
+	if _, err = s.GetURL(ctx, "mine"); !errors.Is(err, storage.ErrIsDeleted) {
+		t.Errorf("expected ErrIsDeleted, got %v", err)
+	}
+
+	if _, err = s.GetURL(ctx, "theirs"); err != nil {
+		t.Errorf("url owned by another user must not be deleted, got %v", err)
+	}
+}
+
+func testRestoreBatch(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "mine", FullURL: "https://example.com/mine", UserID: "alice"})
+	mustSave(t, s, storage.URLInput{ShortURL: "theirs", FullURL: "https://example.com/theirs", UserID: "bob"})
+
+	_, err := s.DeleteBatch([]storage.DeleteURLInput{
+		{UserID: "alice", URL: "mine"},
+		{UserID: "bob", URL: "theirs"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected, err := s.RestoreBatch(ctx, "alice", []string{"mine", "theirs", "unknown"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(rejected)
+
+	if len(rejected) != 2 || rejected[0] != "theirs" || rejected[1] != "unknown" {
+		t.Errorf("expected theirs and unknown to be rejected, got %v", rejected)
+	}
+
+	if fullURL, err := s.GetURL(ctx, "mine"); err != nil || fullURL != "https://example.com/mine" {
+		t.Errorf("expected restored url, got %q, %v", fullURL, err)
+	}
+
+	if _, err = s.GetURL(ctx, "theirs"); !errors.Is(err, storage.ErrIsDeleted) {
+		t.Errorf("url owned by another user must stay deleted, got %v", err)
+	}
+
+	rejected, err = s.RestoreBatch(ctx, "alice", []string{"mine"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rejected) != 0 {
+		t.Errorf("restoring a url that is not deleted must not be rejected, got %v", rejected)
+	}
+}
+
+func testDeleteDeleted(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "deleted", FullURL: "https://example.com/deleted", UserID: "user"})
+	mustSave(t, s, storage.URLInput{ShortURL: "kept", FullURL: "https://example.com/kept", UserID: "user"})
+
+	if _, err := s.DeleteBatch([]storage.DeleteURLInput{{UserID: "user", URL: "deleted"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := s.DeleteDeleted(ctx, time.Now().Add(-time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("url deleted within the retention window must survive, purged %d", count)
+	}
+
+	count, err = s.DeleteDeleted(ctx, time.Now().Add(time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("expected 1 purged url, got %d", count)
+	}
+
+	if _, err = s.GetURL(ctx, "deleted"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after purge, got %v", err)
+	}
+
+	if _, err = s.GetURL(ctx, "kept"); err != nil {
+		t.Errorf("url that is not deleted must survive purge, got %v", err)
+	}
+}
+
+func testExpiration(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	mustSave(t, s, storage.URLInput{ShortURL: "old", FullURL: "https://example.com/old", UserID: "user", ExpiresAt: now.Add(-time.Hour)})
+	mustSave(t, s, storage.URLInput{ShortURL: "new", FullURL: "https://example.com/new", UserID: "user", ExpiresAt: now.Add(time.Hour)})
+
+	if _, err := s.GetURL(ctx, "old"); !errors.Is(err, storage.ErrIsExpired) {
+		t.Errorf("expected ErrIsExpired, got %v", err)
+	}
+
+	count, err := s.DeleteExpired(ctx, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 1 {
+		t.Errorf("expected 1 purged url, got %d", count)
+	}
+
+	if _, err = s.GetURL(ctx, "old"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after purge, got %v", err)
+	}
+
+	if _, err = s.GetURL(ctx, "new"); err != nil {
+		t.Errorf("unexpired url must survive purge, got %v", err)
+	}
+}
+
+func testExpiredOriginalReplaced(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	mustSave(t, s, storage.URLInput{ShortURL: "old", FullURL: "https://example.com/", UserID: "user", ExpiresAt: time.Now().Add(-time.Hour)})
+
+	if _, err := s.GetByOriginalURL(ctx, "https://example.com/"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expired url must not be found by its original url, got %v", err)
+	}
+
+	mustSave(t, s, storage.URLInput{ShortURL: "new", FullURL: "https://example.com/", UserID: "user"})
+	err := s.SaveBatch(ctx, []storage.URLInput{{ShortURL: "other", FullURL: "https://example.com/", UserID: "user"}})
+
+	if !errors.Is(err, storage.ErrAlreadyExist) {
+		t.Errorf("expected ErrAlreadyExist for the replacement, got %v", err)
+	}
+
+	shortURL, err := s.GetByOriginalURL(ctx, "https://example.com/")
+
+	if err != nil || shortURL != "new" {
+		t.Errorf("expected new, got %q, %v", shortURL, err)
+	}
+
+	if _, err = s.DeleteExpired(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.GetURL(ctx, "new"); err != nil {
+		t.Errorf("replacement must survive purge, got %v", err)
+	}
+}
+
+func testConcurrentAccess(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	const goroutines = 16
+	const perGoroutine = 20
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var conflicts, saved int
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < perGoroutine; i++ {
+				shortURL := fmt.Sprintf("g%d-%d", g, i)
+				err := s.SaveURL(ctx, storage.URLInput{
+					ShortURL: shortURL,
+					FullURL:  fmt.Sprintf("https://example.com/%d", i),
+					UserID:   fmt.Sprintf("user%d", g),
+				})
+
+				mutex.Lock()
+				switch {
+				case err == nil:
+					saved++
+				case errors.Is(err, storage.ErrAlreadyExist):
+					conflicts++
+				default:
+					t.Errorf("unexpected error: %v", err)
+				}
+				mutex.Unlock()
+
+				if _, err = s.GetURL(ctx, shortURL); err != nil && !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				if _, err = s.GetURLsByUserID(ctx, fmt.Sprintf("user%d", g)); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	if saved != perGoroutine || conflicts != (goroutines-1)*perGoroutine {
+		t.Errorf("expected %d saved and %d conflicts, got %d and %d", perGoroutine, (goroutines-1)*perGoroutine, saved, conflicts)
+	}
+}
+
+func testCounter(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	const goroutines = 8
+	const perGoroutine = 50
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	seen := make(map[uint64]bool)
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			last := uint64(0)
+
+			for i := 0; i < perGoroutine; i++ {
+				n, err := s.NextCounter(ctx)
+
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+
+				if n <= last {
+					t.Errorf("counter went from %d to %d", last, n)
+				}
+
+				last = n
+				mutex.Lock()
+
+				if seen[n] {
+					t.Errorf("counter %d returned twice", n)
+				}
+
+				seen[n] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// testCounterSurvivesReopen shortens urls with keys taken from the counter the
+// way the counter slug generator does and checks that keys issued after a
+// restart do not collide with the ones issued before.
+func testCounterSurvivesReopen(t *testing.T, open func() storage.Storage) {
+	ctx := context.Background()
+	max := uint64(0)
+
+	for run := 0; run < 3; run++ {
+		s := open()
+
+		for i := 0; i < 5; i++ {
+			n, err := s.NextCounter(ctx)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n <= max {
+				t.Fatalf("run %d: counter %d is not above %d issued before", run, n, max)
+			}
+
+			max = n
+			mustSave(t, s, storage.URLInput{
+				ShortURL: fmt.Sprint(n),
+				FullURL:  fmt.Sprintf("https://example.com/%d", n),
+				UserID:   "user",
+			})
+		}
+	}
+}
+
+func mustSave(t *testing.T, s storage.Storage, input storage.URLInput) {
+	t.Helper()
+
+	if err := s.SaveURL(context.Background(), input); err != nil {
+		t.Fatal(err)
+	}
+}
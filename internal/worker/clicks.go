@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+type ClickRecorder struct {
+	storage       storage.ClickStorage
+	clicks        chan storage.Click
+	batchSize     int
+	flushInterval time.Duration
+	dropped       uint64
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+func NewClickRecorder(clickStorage storage.ClickStorage, bufferSize int, batchSize int, flushInterval time.Duration) *ClickRecorder {
+	return &ClickRecorder{
+		storage:       clickStorage,
+		clicks:        make(chan storage.Click, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (r *ClickRecorder) Start() {
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.flushInterval)
+		defer ticker.Stop()
+		batch := make([]storage.Click, 0, r.batchSize)
+
+		for {
+			select {
+			case click := <-r.clicks:
+				batch = append(batch, click)
+				if len(batch) >= r.batchSize {
+					batch = r.flush(batch)
+				}
+			case <-ticker.C:
+				batch = r.flush(batch)
+			case <-r.stop:
+				for {
+					select {
+					case click := <-r.clicks:
+						batch = append(batch, click)
+					default:
+						r.flush(batch)
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Record never blocks the redirect path: when the buffer is full the click is dropped.
+func (r *ClickRecorder) Record(click storage.Click) {
+	select {
+	case r.clicks <- click:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+	}
+}
+
+func (r *ClickRecorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+func (r *ClickRecorder) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *ClickRecorder) flush(batch []storage.Click) []storage.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.storage.SaveClicks(ctx, batch); err != nil {
+		log.Println(err)
+	}
+
+	return batch[:0]
+}
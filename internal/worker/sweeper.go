@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+type Sweeper struct {
+	storage          storage.Storage
+	journal          storage.DeletionJournal
+	clickStorage     storage.ClickStorage
+	interval         time.Duration
+	retention        time.Duration
+	deletedRetention time.Duration
+	jobsRetention    time.Duration
+	clicksRetention  time.Duration
+	stop             chan struct{}
+	done             chan struct{}
+}
+
+// NewSweeper purges, every interval, urls expired for longer than retention,
+// urls deleted for longer than deletedRetention, deletion jobs finished for
+// longer than jobsRetention and clicks older than clicksRetention; a zero
+// clicksRetention keeps clicks forever.
+func NewSweeper(
+	urlStorage storage.Storage,
+	journal storage.DeletionJournal,
+	clickStorage storage.ClickStorage,
+	interval time.Duration,
+	retention time.Duration,
+	deletedRetention time.Duration,
+	jobsRetention time.Duration,
+	clicksRetention time.Duration) *Sweeper {
+	return &Sweeper{
+		storage:          urlStorage,
+		journal:          journal,
+		clickStorage:     clickStorage,
+		interval:         interval,
+		retention:        retention,
+		deletedRetention: deletedRetention,
+		jobsRetention:    jobsRetention,
+		clicksRetention:  clicksRetention,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+}
+
+func (s *Sweeper) Start() {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Sweeper) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Sweeper) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+	count, err := s.storage.DeleteExpired(ctx, time.Now().Add(-s.retention))
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if count > 0 {
+		log.Printf("sweeper: purged %d expired urls\n", count)
+	}
+
+	count, err = s.storage.DeleteDeleted(ctx, time.Now().Add(-s.deletedRetention))
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if count > 0 {
+		log.Printf("sweeper: purged %d deleted urls\n", count)
+	}
+
+	count, err = s.journal.PurgeFinished(ctx, time.Now().Add(-s.jobsRetention))
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if count > 0 {
+		log.Printf("sweeper: purged %d finished deletions\n", count)
+	}
+
+	if s.clicksRetention == 0 {
+		return
+	}
+
+	count, err = s.clickStorage.DeleteClicks(ctx, time.Now().Add(-s.clicksRetention))
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if count > 0 {
+		log.Printf("sweeper: purged %d clicks\n", count)
+	}
+}
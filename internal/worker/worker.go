@@ -1,76 +1,302 @@
 package worker
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/iamsorryprincess/url-shortener/internal/storage"
 )
 
+var ErrQueueFull = errors.New("deletion queue is full")
+var ErrTooManyURLs = errors.New("more urls than the deletion queue can hold")
+var ErrNoURLs = errors.New("no urls to delete")
+var ErrWorkerStopped = errors.New("deletion worker is stopped")
+
 type Worker struct {
-	balancerChannel chan storage.DeleteURLInput
-	inputChannels   []chan storage.DeleteURLInput
-	storage         storage.Storage
+	mutex     sync.Mutex
+	queue     chan storage.DeleteURLInput
+	reserved  int
+	storage   storage.Storage
+	journal   storage.DeletionJournal
+	stopped   bool
+	done      chan struct{}
+	consumers sync.WaitGroup
 }
 
-func NewWorker(storage storage.Storage) *Worker {
+func NewWorker(urlStorage storage.Storage, journal storage.DeletionJournal, queueSize int) *Worker {
 	return &Worker{
-		storage: storage,
+		queue:   make(chan storage.DeleteURLInput, queueSize),
+		done:    make(chan struct{}),
+		storage: urlStorage,
+		journal: journal,
 	}
 }
 
-func (w *Worker) Start(workersCount int, poolSize int) {
-	go func(workersCount int, poolSize int) {
-		w.inputChannels = make([]chan storage.DeleteURLInput, workersCount)
-
-		for i := 0; i < workersCount; i++ {
-			w.inputChannels[i] = make(chan storage.DeleteURLInput)
-			go func(poolSize int, ch chan storage.DeleteURLInput) {
-				pool := make([]storage.DeleteURLInput, poolSize)
-				count := 0
-				for urlData := range ch {
-					pool[count] = urlData
-					if count == poolSize-1 {
-						err := w.storage.DeleteBatch(pool)
-						if err != nil {
-							log.Println(err)
-						}
-						count = 0
-						continue
-					}
-					count++
-				}
-			}(poolSize, w.inputChannels[i])
+// maxRetryBackoff caps the wait between attempts to apply a pool after the
+// storage failed.
+const maxRetryBackoff = time.Minute
+
+// Start runs workersCount consumers; each one deletes its pool once it holds
+// poolSize urls or once flushInterval has passed, whichever comes first.
+func (w *Worker) Start(workersCount int, poolSize int, flushInterval time.Duration) {
+	for i := 0; i < workersCount; i++ {
+		w.consumers.Add(1)
+		go w.consume(poolSize, flushInterval)
+	}
+}
+
+// consume keeps a pool that the storage failed to apply and retries it with a
+// growing backoff. A full pool stops taking urls meanwhile, so the queue fills
+// up and Process pushes back on clients.
+func (w *Worker) consume(poolSize int, flushInterval time.Duration) {
+	defer w.consumers.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	pool := make([]storage.DeleteURLInput, 0, poolSize)
+	done := w.done
+	stopping := false
+	var backoff time.Duration
+	var retryAt time.Time
+
+	flush := func() {
+		if len(pool) == 0 || (!stopping && time.Now().Before(retryAt)) {
+			return
+		}
+
+		// on shutdown a failed pool is dropped: its urls stay pending in the
+		// journal and are retried by Recover after the next start
+		if w.deleteBatch(pool) || stopping {
+			pool = pool[:0]
+			backoff = 0
+			retryAt = time.Time{}
+			return
+		}
+
+		backoff *= 2
+
+		if backoff < flushInterval {
+			backoff = flushInterval
 		}
 
-		w.balancerChannel = make(chan storage.DeleteURLInput)
-		count := 0
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+
+		retryAt = time.Now().Add(backoff)
+		log.Printf("worker: retrying %d deletions in %s, queue depth %d\n", len(pool), backoff, len(w.queue))
+	}
+
+	for {
+		queue := w.queue
+
+		if len(pool) >= poolSize && !stopping {
+			queue = nil
+		}
 
-		for urlData := range w.balancerChannel {
-			w.inputChannels[count] <- urlData
-			if count == workersCount-1 {
-				count = 0
-				continue
+		select {
+		case urlData, ok := <-queue:
+			if !ok {
+				stopping = true
+				flush()
+				return
 			}
-			count++
+			pool = append(pool, urlData)
+			if len(pool) >= poolSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-done:
+			done = nil
+			stopping = true
+			flush()
+		}
+	}
+}
+
+// Process journals and enqueues all urls or none of them, so a request is never
+// half accepted and an accepted request survives a restart. The returned job id
+// can be passed to JobStatus to follow the deletion.
+func (w *Worker) Process(ctx context.Context, userID string, urls []string) (string, error) {
+	// a job without urls would have nothing in the journal to report on
+	if len(urls) == 0 {
+		return "", ErrNoURLs
+	}
+
+	if len(urls) > cap(w.queue) {
+		return "", ErrTooManyURLs
+	}
+
+	if err := w.reserve(len(urls)); err != nil {
+		return "", err
+	}
+
+	jobID := uuid.New().String()
+	input := make([]storage.DeleteURLInput, len(urls))
+
+	for i, url := range urls {
+		input[i] = storage.DeleteURLInput{
+			JobID:  jobID,
+			UserID: userID,
+			URL:    url,
 		}
+	}
+
+	// the journal is written without holding the mutex, so a slow journal does
+	// not block other requests; the reservation keeps room in the queue
+	err := w.journal.Append(ctx, input)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.reserved -= len(urls)
 
-		for _, ch := range w.inputChannels {
-			close(ch)
+	if err != nil {
+		return "", err
+	}
+
+	// a journaled deletion is accepted even if the worker stopped meanwhile:
+	// Recover applies it after the next start
+	if !w.stopped {
+		for _, data := range input {
+			w.queue <- data
 		}
-	}(workersCount, poolSize)
+	}
+
+	return jobID, nil
+}
+
+// reserve makes room for count urls in the queue or reports why there is none.
+func (w *Worker) reserve(count int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped {
+		return ErrWorkerStopped
+	}
+
+	if depth := len(w.queue) + w.reserved; depth+count > cap(w.queue) {
+		log.Printf("worker: rejected %d deletions, queue depth %d of %d\n", count, depth, cap(w.queue))
+		return fmt.Errorf("%w: %d of %d urls queued", ErrQueueFull, depth, cap(w.queue))
+	}
+
+	w.reserved += count
+	return nil
+}
+
+// JobStatus returns the status of every url of the job, or storage.ErrNotFound
+// if the user has no such job.
+func (w *Worker) JobStatus(ctx context.Context, userID string, jobID string) ([]storage.DeletionStatus, error) {
+	return w.journal.GetJob(ctx, userID, jobID)
+}
+
+// Recover enqueues deletions that were accepted but not applied before the
+// previous shutdown. It must be called after Start.
+func (w *Worker) Recover(ctx context.Context) error {
+	pending, err := w.journal.Pending(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped {
+		return ErrWorkerStopped
+	}
+
+	for _, data := range pending {
+		w.queue <- data
+	}
+
+	if len(pending) > 0 {
+		log.Printf("worker: recovered %d pending deletions\n", len(pending))
+	}
+
+	return nil
 }
 
-func (w *Worker) Process(userID string, urls []string) {
-	go func(userID string, urls []string) {
-		for _, url := range urls {
-			w.balancerChannel <- storage.DeleteURLInput{
-				UserID: userID,
-				URL:    url,
+// QueueDepth counts queued urls and urls of requests that are being journaled.
+func (w *Worker) QueueDepth() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.queue) + w.reserved
+}
+
+// Report logs the queue depth every interval while deletions are waiting, so
+// that a queue that keeps growing shows up before Process starts rejecting
+// requests. It returns once the worker is stopped.
+func (w *Worker) Report(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if depth := w.QueueDepth(); depth > 0 {
+					log.Printf("worker: queue depth %d of %d\n", depth, cap(w.queue))
+				}
+			case <-w.done:
+				return
 			}
 		}
-	}(userID, urls)
+	}()
 }
 
+// Stop rejects new deletions, flushes the queue and partially filled pools and
+// returns once every batch has been written to the storage or, if the storage
+// fails, left pending in the journal.
 func (w *Worker) Stop() {
-	close(w.balancerChannel)
+	w.mutex.Lock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.queue)
+		close(w.done)
+	}
+	w.mutex.Unlock()
+	w.consumers.Wait()
+}
+
+// deleteBatch reports whether the pool was applied; on a storage error the urls
+// stay pending in the journal.
+func (w *Worker) deleteBatch(pool []storage.DeleteURLInput) bool {
+	rejected, err := w.storage.DeleteBatch(pool)
+
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	if err = w.journal.Complete(context.Background(), completed(pool, rejected), rejected); err != nil {
+		log.Println(err)
+	}
+
+	return true
+}
+
+func completed(pool []storage.DeleteURLInput, rejected []storage.DeleteURLInput) []storage.DeleteURLInput {
+	if len(rejected) == 0 {
+		return pool
+	}
+
+	failed := make(map[storage.DeleteURLInput]bool, len(rejected))
+
+	for _, data := range rejected {
+		failed[data] = true
+	}
+
+	result := make([]storage.DeleteURLInput, 0, len(pool)-len(rejected))
+
+	for _, data := range pool {
+		if !failed[data] {
+			result = append(result, data)
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,329 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iamsorryprincess/url-shortener/internal/storage"
+)
+
+func TestWorkerStopFlushesPartialPools(t *testing.T) {
+	ctx := context.Background()
+	urlStorage := storage.NewInMemoryStorage()
+	err := urlStorage.SaveBatch(ctx, []storage.URLInput{
+		{ShortURL: "one", FullURL: "https://example.com/1", UserID: "user"},
+		{ShortURL: "two", FullURL: "https://example.com/2", UserID: "user"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := NewWorker(urlStorage, storage.NewInMemoryDeletionJournal(), 10)
+	worker.Start(2, 10, time.Hour)
+
+	if _, err = worker.Process(context.Background(), "user", []string{"one", "two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	worker.Stop()
+
+	for _, shortURL := range []string{"one", "two"} {
+		if _, err = urlStorage.GetURL(ctx, shortURL); !errors.Is(err, storage.ErrIsDeleted) {
+			t.Errorf("expected %s to be deleted, got %v", shortURL, err)
+		}
+	}
+}
+
+func TestWorkerFlushesOnInterval(t *testing.T) {
+	ctx := context.Background()
+	urlStorage := storage.NewInMemoryStorage()
+
+	if err := urlStorage.SaveURL(ctx, storage.URLInput{ShortURL: "one", FullURL: "https://example.com/1", UserID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	worker := NewWorker(urlStorage, storage.NewInMemoryDeletionJournal(), 10)
+	worker.Start(1, 10, 10*time.Millisecond)
+	defer worker.Stop()
+
+	if _, err := worker.Process(context.Background(), "user", []string{"one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		_, err := urlStorage.GetURL(ctx, "one")
+
+		if errors.Is(err, storage.ErrIsDeleted) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("partial pool was not flushed, got %v", err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// flakyStorage fails the first failures calls to DeleteBatch.
+type flakyStorage struct {
+	storage.Storage
+	mutex    sync.Mutex
+	failures int
+}
+
+func (s *flakyStorage) DeleteBatch(input []storage.DeleteURLInput) ([]storage.DeleteURLInput, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("storage is unavailable")
+	}
+
+	return s.Storage.DeleteBatch(input)
+}
+
+func TestWorkerRetriesFailedPools(t *testing.T) {
+	ctx := context.Background()
+	urlStorage := &flakyStorage{Storage: storage.NewInMemoryStorage(), failures: 2}
+
+	if err := urlStorage.SaveURL(ctx, storage.URLInput{ShortURL: "one", FullURL: "https://example.com/1", UserID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	journal := storage.NewInMemoryDeletionJournal()
+	worker := NewWorker(urlStorage, journal, 10)
+	worker.Start(1, 1, 10*time.Millisecond)
+	defer worker.Stop()
+
+	if _, err := worker.Process(ctx, "user", []string{"one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+
+	for {
+		pending, err := journal.Pending(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("failed pool was not retried, %d deletions pending", len(pending))
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := urlStorage.GetURL(ctx, "one"); !errors.Is(err, storage.ErrIsDeleted) {
+		t.Errorf("expected ErrIsDeleted, got %v", err)
+	}
+}
+
+func TestWorkerBackpressure(t *testing.T) {
+	worker := NewWorker(storage.NewInMemoryStorage(), storage.NewInMemoryDeletionJournal(), 2)
+
+	if _, err := worker.Process(context.Background(), "user", nil); !errors.Is(err, ErrNoURLs) {
+		t.Errorf("expected ErrNoURLs, got %v", err)
+	}
+
+	if _, err := worker.Process(context.Background(), "user", []string{"one", "two", "three"}); !errors.Is(err, ErrTooManyURLs) {
+		t.Errorf("expected ErrTooManyURLs, got %v", err)
+	}
+
+	if _, err := worker.Process(context.Background(), "user", []string{"one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := worker.Process(context.Background(), "user", []string{"two", "three"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	if _, err := worker.Process(context.Background(), "user", []string{"two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if depth := worker.QueueDepth(); depth != 2 {
+		t.Errorf("expected queue depth 2, got %d", depth)
+	}
+
+	worker.Start(1, 10, time.Hour)
+	worker.Stop()
+
+	if _, err := worker.Process(context.Background(), "user", []string{"one"}); !errors.Is(err, ErrWorkerStopped) {
+		t.Errorf("expected ErrWorkerStopped, got %v", err)
+	}
+}
+
+func TestWorkerRecoversJournaledDeletions(t *testing.T) {
+	ctx := context.Background()
+	journalPath := filepath.Join(t.TempDir(), "deletions")
+	urlStorage := storage.NewInMemoryStorage()
+
+	if err := urlStorage.SaveURL(ctx, storage.URLInput{ShortURL: "one", FullURL: "https://example.com/1", UserID: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, closer, err := storage.NewFileDeletionJournal(journalPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deletion is accepted but the worker never runs, as if the process crashed
+	if _, err = NewWorker(urlStorage, journal, 10).Process(ctx, "user", []string{"one"}); err != nil {
+		t.Fatal(err)
+	}
+
+	closer.Close()
+	journal, closer, err = storage.NewFileDeletionJournal(journalPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer closer.Close()
+	worker := NewWorker(urlStorage, journal, 10)
+	worker.Start(1, 10, time.Hour)
+
+	if err = worker.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	worker.Stop()
+
+	if _, err = urlStorage.GetURL(ctx, "one"); !errors.Is(err, storage.ErrIsDeleted) {
+		t.Errorf("expected recovered deletion to be applied, got %v", err)
+	}
+
+	pending, err := journal.Pending(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 0 {
+		t.Errorf("expected empty journal, got %v", pending)
+	}
+}
+
+func TestWorkerReportsJobStatus(t *testing.T) {
+	ctx := context.Background()
+	urlStorage := storage.NewInMemoryStorage()
+	err := urlStorage.SaveBatch(ctx, []storage.URLInput{
+		{ShortURL: "mine", FullURL: "https://example.com/1", UserID: "user"},
+		{ShortURL: "theirs", FullURL: "https://example.com/2", UserID: "other"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := NewWorker(urlStorage, storage.NewInMemoryDeletionJournal(), 10)
+	jobID, err := worker.Process(ctx, "user", []string{"mine", "theirs", "unknown"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := worker.JobStatus(ctx, "user", jobID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range statuses {
+		if status.Status != storage.DeletionPending {
+			t.Errorf("expected %s to be pending, got %s", status.URL, status.Status)
+		}
+	}
+
+	worker.Start(1, 10, time.Hour)
+	worker.Stop()
+
+	statuses, err = worker.JobStatus(ctx, "user", jobID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []storage.DeletionStatus{
+		{URL: "mine", Status: storage.DeletionCompleted},
+		{URL: "theirs", Status: storage.DeletionFailed},
+		{URL: "unknown", Status: storage.DeletionFailed},
+	}
+
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, statuses)
+	}
+
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], statuses[i])
+		}
+	}
+
+	if _, err = worker.JobStatus(ctx, "other", jobID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another user, got %v", err)
+	}
+}
+
+// syncBuffer lets the test read what the reporting goroutine logs.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestWorkerReportsQueueDepth(t *testing.T) {
+	output := &syncBuffer{}
+	log.SetOutput(output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	worker := NewWorker(storage.NewInMemoryStorage(), storage.NewInMemoryDeletionJournal(), 10)
+
+	if _, err := worker.Process(context.Background(), "user", []string{"one", "two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	worker.Report(time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+
+	for !strings.Contains(output.String(), "queue depth 2 of 10") {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth was not reported, logged %q", output.String())
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	worker.Stop()
+}
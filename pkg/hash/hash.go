@@ -1,74 +1,231 @@
 package hash
 
 import (
+	"bufio"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
-	"math/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 )
 
+var ErrInvalidToken = errors.New("invalid token")
+var ErrTokenExpired = errors.New("token expired")
+var ErrUnknownKey = errors.New("unknown token key")
+var ErrNoKeys = errors.New("no keys")
+
 type KeyManager interface {
-	Encode(key string) string
+	Encode(key string) (string, error)
 	Decode(key string) (string, error)
 }
 
+// Key is an AES key identified by ID; the ID is written in front of every token
+// so that tokens issued with a rotated-out key can still be decoded.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+type Claims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
 type gcmKeyManager struct {
-	aesBlock cipher.Block
-	aesGcm   cipher.AEAD
-	nonce    []byte
+	activeID string
+	ciphers  map[string]cipher.AEAD
+	ttl      time.Duration
+	now      func() time.Time
 }
 
-func NewGcmKeyManager() (KeyManager, error) {
-	key, err := generateRandomBytes(aes.BlockSize)
+// NewGcmKeyManager seals tokens with the first key and opens tokens sealed with
+// any of the keys. Tokens never expire when ttl is zero.
+func NewGcmKeyManager(keys []Key, ttl time.Duration) (KeyManager, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
 
-	if err != nil {
-		return nil, err
+	ciphers := make(map[string]cipher.AEAD, len(keys))
+
+	for _, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			return nil, fmt.Errorf("invalid key id %q", key.ID)
+		}
+
+		if _, ok := ciphers[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+
+		aesBlock, err := aes.NewCipher(key.Secret)
+
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.ID, err)
+		}
+
+		aesGcm, err := cipher.NewGCM(aesBlock)
+
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.ID, err)
+		}
+
+		ciphers[key.ID] = aesGcm
 	}
 
-	aesBlock, err := aes.NewCipher(key)
+	return &gcmKeyManager{
+		activeID: keys[0].ID,
+		ciphers:  ciphers,
+		ttl:      ttl,
+		now:      time.Now,
+	}, nil
+}
+
+// Encode returns "<key id>.<base64(nonce | sealed claims)>" with a fresh nonce
+// for every token.
+func (m *gcmKeyManager) Encode(key string) (string, error) {
+	now := m.now()
+	claims := Claims{
+		Subject:  key,
+		IssuedAt: now.Unix(),
+	}
 
-	if err != nil {
-		return nil, err
+	if m.ttl > 0 {
+		claims.ExpiresAt = now.Add(m.ttl).Unix()
 	}
 
-	aesGcm, err := cipher.NewGCM(aesBlock)
+	plaintext, err := json.Marshal(claims)
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
+	aesGcm := m.ciphers[m.activeID]
 	nonce, err := generateRandomBytes(aesGcm.NonceSize())
 
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return &gcmKeyManager{
-		aesBlock: aesBlock,
-		aesGcm:   aesGcm,
-		nonce:    nonce,
-	}, nil
+	sealed := aesGcm.Seal(nonce, nonce, plaintext, []byte(m.activeID))
+	return m.activeID + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
 }
 
-func (m *gcmKeyManager) Encode(key string) string {
-	dst := m.aesGcm.Seal(nil, m.nonce, []byte(key), nil)
-	return hex.EncodeToString(dst)
+func (m *gcmKeyManager) Decode(key string) (string, error) {
+	keyID, encoded, ok := strings.Cut(key, ".")
+
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	aesGcm, ok := m.ciphers[keyID]
+
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	src, err := base64.RawURLEncoding.DecodeString(encoded)
+
+	if err != nil || len(src) < aesGcm.NonceSize() {
+		return "", ErrInvalidToken
+	}
+
+	nonce, sealed := src[:aesGcm.NonceSize()], src[aesGcm.NonceSize():]
+	plaintext, err := aesGcm.Open(nil, nonce, sealed, []byte(keyID))
+
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	var claims Claims
+
+	if err = json.Unmarshal(plaintext, &claims); err != nil || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+
+	if claims.ExpiresAt != 0 && m.now().Unix() >= claims.ExpiresAt {
+		return "", ErrTokenExpired
+	}
+
+	return claims.Subject, nil
 }
 
-func (m *gcmKeyManager) Decode(key string) (string, error) {
-	src, err := hex.DecodeString(key)
+// ParseKeys parses a comma or newline separated list of "<id>:<hex secret>"
+// pairs; the first key is the active one.
+func ParseKeys(value string) ([]Key, error) {
+	var keys []Key
+
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		item = strings.TrimSpace(item)
+
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(item, ":")
+
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q: expected <id>:<hex secret>", item)
+		}
+
+		bytes, err := hex.DecodeString(strings.TrimSpace(secret))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+
+		keys = append(keys, Key{
+			ID:     strings.TrimSpace(id),
+			Secret: bytes,
+		})
+	}
+
+	return keys, nil
+}
+
+// LoadKeys reads keys in the ParseKeys format from a file, one key per line.
+func LoadKeys(path string) ([]Key, error) {
+	file, err := os.Open(path)
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	result, err := m.aesGcm.Open(nil, m.nonce, src, nil)
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ParseKeys(strings.Join(lines, "\n"))
+}
+
+// GenerateKey returns a random AES-256 key, meant for development setups that
+// did not configure any key.
+func GenerateKey(id string) (Key, error) {
+	secret, err := generateRandomBytes(32)
 
 	if err != nil {
-		return "", err
+		return Key{}, err
 	}
 
-	return string(result), nil
+	return Key{
+		ID:     id,
+		Secret: secret,
+	}, nil
 }
 
 func generateRandomBytes(size int) ([]byte, error) {
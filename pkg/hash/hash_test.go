@@ -0,0 +1,138 @@
+package hash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T, id string) Key {
+	t.Helper()
+	key, err := GenerateKey(id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// tamper replaces a character of the sealed part of the token.
+func tamper(token string) string {
+	index := strings.Index(token, ".") + 5
+	replacement := "A"
+
+	if token[index] == 'A' {
+		replacement = "B"
+	}
+
+	return token[:index] + replacement + token[index+1:]
+}
+
+func TestGcmKeyManager(t *testing.T) {
+	oldKey := newTestKey(t, "old")
+	newKey := newTestKey(t, "new")
+
+	oldManager, err := NewGcmKeyManager([]Key{oldKey}, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotatedManager, err := NewGcmKeyManager([]Key{newKey, oldKey}, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldToken, err := oldManager.Encode("user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondToken, err := oldManager.Encode("user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := rotatedManager.Encode("user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		manager KeyManager
+		token   string
+		want    string
+		wantErr error
+	}{
+		{name: "same key", manager: oldManager, token: oldToken, want: "user"},
+		{name: "rotated out key", manager: rotatedManager, token: oldToken, want: "user"},
+		{name: "active key", manager: rotatedManager, token: newToken, want: "user"},
+		{name: "unknown key", manager: oldManager, token: newToken, wantErr: ErrUnknownKey},
+		{name: "tampered", manager: oldManager, token: tamper(oldToken), wantErr: ErrInvalidToken},
+		{name: "swapped key id", manager: rotatedManager, token: "new" + strings.TrimPrefix(oldToken, "old"), wantErr: ErrInvalidToken},
+		{name: "garbage", manager: oldManager, token: "garbage", wantErr: ErrInvalidToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.manager.Decode(test.token)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("expected %v, got %v", test.wantErr, err)
+			}
+
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+
+	if oldToken == secondToken {
+		t.Error("tokens must use a fresh nonce")
+	}
+}
+
+func TestGcmKeyManagerExpiry(t *testing.T) {
+	manager, err := NewGcmKeyManager([]Key{newTestKey(t, "key")}, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	manager.(*gcmKeyManager).now = func() time.Time { return now }
+	token, err := manager.Encode("user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager.(*gcmKeyManager).now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	if _, err = manager.Decode(token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("current:000102030405060708090a0b0c0d0e0f, previous:0f0e0d0c0b0a09080706050403020100")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0].ID != "current" || keys[1].ID != "previous" || len(keys[0].Secret) != 16 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	if _, err = ParseKeys("missing-secret"); err == nil {
+		t.Error("expected an error for a key without a secret")
+	}
+}